@@ -0,0 +1,115 @@
+package mapimage
+
+import (
+	"errors"
+	"gonum.org/v1/gonum/mat"
+)
+
+type AffineTransformation struct {
+	trans *mat.Dense
+}
+
+func NewAffineTransformation(a, b, c, d, Tx, Ty float64) AffineTransformation {
+	//   --
+	// | a  |
+	// | b  |
+	// | c  |
+	// | d  |
+	// | Tx |
+	// | Ty |
+	//   --
+	//
+	// Such that:
+	//   e = a*x + b*y + Tx
+	//   n = c*x + d*y + Ty
+
+	trans := mat.NewDense(6, 1, []float64{a, b, c, d, Tx, Ty})
+	return AffineTransformation{trans}
+}
+
+// The full affine can also rotate and shear, fitted to 3 or more points:
+//
+//	proj := NewAffineTransformationFromPoints([A, B, C, ...], [a, b, c, ...])
+//	proj.Project(d) => returns D
+func NewAffineTransformationFromPoints(standardPoints []Point, localPoints []Point) (AffineTransformation, error) {
+	if len(standardPoints) != len(localPoints) {
+		return AffineTransformation{}, errors.New("affine: mismatched number of points")
+	}
+	if len(standardPoints) < 3 {
+		return AffineTransformation{}, errors.New("affine: at least 3 points are required")
+	}
+
+	//          X              *    t    =    e
+	//   -------------------       ----      ----
+	//  | x1 y1  0  0  1  0 |     | a  |    | e1 |
+	//  |  0  0 x1 y1  0  1 |     | b  |    | n1 |
+	//  | x2 y2  0  0  1  0 |  *  | c  | =  | e2 |
+	//  |  0  0 x2 y2  0  1 |     | d  |    | n2 |
+	//  |  .  .  .  .  .  . |     | Tx |    |  . |
+	//  | xN yN  0  0  1  0 |     | Ty |    | eN |
+	//  |  0  0 xN yN  0  1 |      ----     | nN |
+	//   -------------------                 ----
+	n := len(standardPoints)
+	E := mat.NewDense(2*n, 1, nil)
+	X := mat.NewDense(2*n, 6, nil)
+	for i := range standardPoints {
+		E.Set(2*i, 0, standardPoints[i].Lng)
+		E.Set(2*i+1, 0, standardPoints[i].Lat)
+
+		y, x := localPoints[i].Lat, localPoints[i].Lng
+		X.SetRow(2*i, []float64{x, y, 0, 0, 1, 0})
+		X.SetRow(2*i+1, []float64{0, 0, x, y, 0, 1})
+	}
+
+	// With more than 3 points the system is over-determined, in which case
+	// Solve finds the least squares solution
+	var trans mat.Dense
+	err := trans.Solve(X, E)
+	if err != nil {
+		return AffineTransformation{}, err
+	}
+
+	return AffineTransformation{&trans}, nil
+}
+
+func (t *AffineTransformation) Inverse() Transformation {
+	a, b, c, d := t.trans.At(0, 0), t.trans.At(1, 0), t.trans.At(2, 0), t.trans.At(3, 0)
+	Tx, Ty := t.trans.At(4, 0), t.trans.At(5, 0)
+
+	//   --    -- -1                 --     --
+	//  | a  b  |       =   1/det * |  d  -b  |
+	//  | c  d  |                   | -c   a  |
+	//   --    --                    --     --
+	det := a*d - b*c
+	ia, ib, ic, id := d/det, -b/det, -c/det, a/det
+	inv := NewAffineTransformation(ia, ib, ic, id, -(ia*Tx + ib*Ty), -(ic*Tx + id*Ty))
+	return &inv
+}
+
+func (t *AffineTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *AffineTransformation) Projects(points ...Point) (results []Point) {
+	if len(points) == 0 {
+		return
+	}
+
+	X := mat.NewDense(2*len(points), 6, nil)
+	for i, p := range points {
+		y := p.Lat
+		x := p.Lng
+		X.SetRow(2*i, []float64{x, y, 0, 0, 1, 0})
+		X.SetRow(2*i+1, []float64{0, 0, x, y, 0, 1})
+	}
+
+	var transformed mat.Dense
+	transformed.Mul(X, t.trans)
+
+	for i, _ := range points {
+		easting := transformed.At(2*i, 0)
+		northing := transformed.At(2*i+1, 0)
+		results = append(results, Point{Lat: northing, Lng: easting})
+	}
+	return
+}
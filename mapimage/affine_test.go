@@ -0,0 +1,89 @@
+package mapimage
+
+import (
+	"math"
+	"testing"
+)
+
+// A rotation of 30 degrees, a scale of 2 in x and 3 in y, a small shear and
+// then a translation of (10, -20)
+var affineTestParams = struct {
+	a, b, c, d, Tx, Ty float64
+}{
+	2 * math.Cos(RadFromDeg(30)), -3*math.Sin(RadFromDeg(30)) + 0.1,
+	2 * math.Sin(RadFromDeg(30)), 3 * math.Cos(RadFromDeg(30)),
+	10, -20,
+}
+
+func affineTestProject(p Point) Point {
+	k := affineTestParams
+	return PointXY(
+		k.a*p.Lng+k.b*p.Lat+k.Tx,
+		k.c*p.Lng+k.d*p.Lat+k.Ty,
+	)
+}
+
+func TestAffineViaConstructor(t *testing.T) {
+	k := affineTestParams
+	sut := NewAffineTransformation(k.a, k.b, k.c, k.d, k.Tx, k.Ty)
+
+	for _, pt := range []Point{PointXY(0, 0), PointXY(1, 0), PointXY(0, 1), PointXY(-7, 12.5)} {
+		result := sut.Project(pt)
+		expect := affineTestProject(pt)
+		if !result.IsCloseTo(expect) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", pt, result, expect)
+		}
+	}
+}
+
+func TestAffineViaThreePoints(t *testing.T) {
+	local := []Point{PointXY(0, 0), PointXY(100, 0), PointXY(0, 100)}
+	standard := make([]Point, len(local))
+	for i, pt := range local {
+		standard[i] = affineTestProject(pt)
+	}
+
+	sut, err := NewAffineTransformationFromPoints(standard, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pt := range []Point{PointXY(50, 50), PointXY(-30, 250), PointXY(1000, 1000)} {
+		result := sut.Project(pt)
+		expect := affineTestProject(pt)
+		if !result.IsCloseTo(expect) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", pt, result, expect)
+		}
+	}
+}
+
+func TestAffineLeastSquares(t *testing.T) {
+	// Five points, with the errors on the extra two points cancelling out
+	local := []Point{PointXY(0, 0), PointXY(100, 0), PointXY(0, 100), PointXY(100, 100), PointXY(100, 100)}
+	standard := make([]Point, len(local))
+	for i, pt := range local {
+		standard[i] = affineTestProject(pt)
+	}
+	standard[3].Lat += 0.5
+	standard[4].Lat -= 0.5
+
+	sut, err := NewAffineTransformationFromPoints(standard, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pt := range local {
+		result := sut.Project(pt)
+		expect := affineTestProject(pt)
+		if !result.IsCloseTo(expect) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", pt, result, expect)
+		}
+	}
+}
+
+func TestAffineNeedsThreePoints(t *testing.T) {
+	points := []Point{PointXY(0, 0), PointXY(1, 1)}
+	if _, err := NewAffineTransformationFromPoints(points, points); err == nil {
+		t.Errorf("expected an error for only two points")
+	}
+}
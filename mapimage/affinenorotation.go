@@ -1,6 +1,7 @@
 package mapimage
 
 import (
+	"errors"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -45,18 +46,12 @@ func NewAffineNoRotTransformation(Sx, Sy, Tx, Ty float64) AffineNoRotTransformat
 	proj.Project(B) => returns b
 */
 func NewAffineNoRotTransformationFromPoints(standardPoints []Point, localPoints []Point) (AffineNoRotTransformation, error) {
-	n1, e1 := standardPoints[0].Lat, standardPoints[0].Lng
-	n2, e2 := standardPoints[1].Lat, standardPoints[1].Lng
-
-	E := mat.NewDense(4, 1, []float64{e1, n1, e2, n2})
-	y1, x1 := localPoints[0].Lat, localPoints[0].Lng
-	y2, x2 := localPoints[1].Lat, localPoints[1].Lng
-	X := mat.NewDense(4, 4, []float64{
-		x1, 0, 1, 0,
-		0, y1, 0, 1,
-		x2, 0, 1, 0,
-		0, y2, 0, 1,
-	})
+	if len(standardPoints) != len(localPoints) {
+		return AffineNoRotTransformation{}, errors.New("affine-norot: mismatched number of points")
+	}
+	if len(standardPoints) < 2 {
+		return AffineNoRotTransformation{}, errors.New("affine-norot: at least 2 points are required")
+	}
 
 	//          X         *    t    =    e
 	//   --------------       ----      ----
@@ -64,13 +59,28 @@ func NewAffineNoRotTransformationFromPoints(standardPoints []Point, localPoints
 	//  |  0 y1  0  1  |  *  | Sy | =  | n1 |
 	//  | x2  0  1  0  |     | Tx |    | e2 |
 	//  |  0 y2  0  1  |     | Ty |    | n2 |
-	//   --------------       ----      ----
+	//  |  .  .  .  .  |      ----     |  . |
+	//  | xN  0  1  0  |               | eN |
+	//  |  0 yN  0  1  |               | nN |
+	//   --------------                 ----
+	n := len(standardPoints)
+	E := mat.NewDense(2*n, 1, nil)
+	X := mat.NewDense(2*n, 4, nil)
+	for i := range standardPoints {
+		E.Set(2*i, 0, standardPoints[i].Lng)
+		E.Set(2*i+1, 0, standardPoints[i].Lat)
+
+		y, x := localPoints[i].Lat, localPoints[i].Lng
+		X.SetRow(2*i, []float64{x, 0, 1, 0})
+		X.SetRow(2*i+1, []float64{0, y, 0, 1})
+	}
 
 	var trans mat.Dense
 	// https://en.wikipedia.org/wiki/System_of_linear_equations
 	// Expressed in the form Ax=b (or Xt=e)
 	// I.e. solve for T
 	// T = X^-1 * E
+	// or, when there are more than 2 points, the least squares solution
 	err := trans.Solve(X, E)
 	if err != nil {
 		return AffineNoRotTransformation{}, err
@@ -79,6 +89,12 @@ func NewAffineNoRotTransformationFromPoints(standardPoints []Point, localPoints
 	return AffineNoRotTransformation{&trans}, nil
 }
 
+func (t *AffineNoRotTransformation) Inverse() Transformation {
+	Sx, Sy, Tx, Ty := t.trans.At(0, 0), t.trans.At(1, 0), t.trans.At(2, 0), t.trans.At(3, 0)
+	inv := NewAffineNoRotTransformation(1/Sx, 1/Sy, -Tx/Sx, -Ty/Sy)
+	return &inv
+}
+
 func (t *AffineNoRotTransformation) Project(p Point) Point {
 	// Project just one point and retrieve it from the returned slice
 	return t.Projects(p)[0]
@@ -1,6 +1,8 @@
 package mapimage
 
 import (
+	"gonum.org/v1/gonum/floats"
+	"math"
 	"testing"
 )
 
@@ -78,3 +80,34 @@ func TestViaTwoPoints(t *testing.T) {
 
 	testPointsInBothProjections(t, fromOne, fromTwo)
 }
+
+func TestViaLeastSquares(t *testing.T) {
+	// B is supplied twice, slightly out in opposite directions, so the errors
+	// cancel each other out
+	coords1 := []Point{PointXY(1, 1), PointXY(5, 5), PointXY(1, 5), PointXY(1, 5)}
+	coords2 := []Point{PointXY(20, 50), PointXY(120, 70), PointXY(20, 70.5), PointXY(20, 69.5)}
+	fromOne, err := NewAffineNoRotTransformationFromPoints(coords2, coords1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromTwo := NewAffineNoRotTransformation(4.0/100, 4.0/20, -20*4.0/100+1, -50*4.0/20+1)
+
+	testPointsInBothProjections(t, fromOne, fromTwo)
+
+	residuals := NewResiduals(&fromOne, coords2, coords1)
+	for i, expect := range []float64{0, 0, 0.5, 0.5} {
+		if !floats.EqualWithinAbs(residuals.Points[i].Distance, expect, 0.0000001) {
+			t.Errorf("incorrect residual for %v, got: %v, want: %v.", coords2[i], residuals.Points[i].Distance, expect)
+		}
+	}
+	if expect := math.Sqrt(0.5 / 4); !floats.EqualWithinAbs(residuals.RMSE, expect, 0.0000001) {
+		t.Errorf("incorrect RMSE, got: %v, want: %v.", residuals.RMSE, expect)
+	}
+}
+
+func TestNeedsTwoPoints(t *testing.T) {
+	points := []Point{PointXY(1, 1)}
+	if _, err := NewAffineNoRotTransformationFromPoints(points, points); err == nil {
+		t.Errorf("expected an error for only one point")
+	}
+}
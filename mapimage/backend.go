@@ -0,0 +1,35 @@
+package mapimage
+
+import (
+	"os"
+	"sort"
+)
+
+// A Backend makes the MapImage for an image file. dir is where it can keep
+// whatever it converts the image to (e.g. ./media/{id}), for the next time.
+type Backend func(id, text string, georef Georeference, options TileOptions, contents *os.File, dir string) (MapImage, error)
+
+// backends is every backend in this build, by name. The libvips one is only
+// there when built with it (-tags vips, and cgo).
+var backends = map[string]Backend{}
+
+// RegisterBackend adds a backend, from the init of the file implementing it
+func RegisterBackend(name string, backend Backend) {
+	backends[name] = backend
+}
+
+// LookupBackend is the backend with the name, if it is in this build
+func LookupBackend(name string) (Backend, bool) {
+	backend, ok := backends[name]
+	return backend, ok
+}
+
+// Backends is the names of the backends in this build, sorted
+func Backends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,48 @@
+package mapimage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestBackends(t *testing.T) {
+	names := Backends()
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("incorrect, not sorted: %v.", names)
+	}
+	// The pure Go ones are always there, whatever the build
+	for _, name := range []string{"go", "chunked"} {
+		if _, ok := LookupBackend(name); !ok {
+			t.Errorf("incorrect for %v, got: %v, want it there.", name, names)
+		}
+	}
+	if _, ok := LookupBackend("gdal"); ok {
+		t.Error("expected no gdal backend")
+	}
+	if _, ok := LookupBackend("vips"); !ok && webPSupported() {
+		t.Error("expected no WebP without libvips")
+	}
+
+	dir, err := ioutil.TempDir("", "backends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	original := testMapImage(t, TileOptions{})
+	f := original.ImageContent().(*os.File)
+	newImage, _ := LookupBackend("chunked")
+	sut, err := newImage("backend", "Backend", original.Georeference(), TileOptions{}, f, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sut.PixelBounds() != original.PixelBounds() {
+		t.Errorf("incorrect, got: %v, want: %v.", sut.PixelBounds(), original.PixelBounds())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pixels.raw")); err != nil {
+		t.Errorf("expected the chunk store in dir: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package mapimage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Calibration is how an image was georeferenced by another program, as the
+// settings that would otherwise be in images/config.yaml
+type Calibration struct {
+	Title string
+	// The image that was calibrated (as it was named on the computer that
+	// calibrated it)
+	Image string
+	// The CRS of the geographic half of the reference points, as an EPSG code
+	// or PROJ string
+	CRS             string
+	ReferencePoints []MapImagePair
+	// The transformation model that the program would have used
+	Transformation string
+}
+
+// ReadCalibration reads an OziExplorer .map or QGIS Georeferencer .points file
+func ReadCalibration(filename string) (Calibration, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return Calibration{}, err
+	}
+	defer f.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".map":
+		return ParseOziMap(f)
+	case ".points":
+		return ParseQGISPoints(f)
+	default:
+		return Calibration{}, fmt.Errorf("calibration: unknown type of file %q", ext)
+	}
+}
+
+// exportReferencePoints are the reference points, or else the corners and centre
+func exportReferencePoints(i MapImage) []MapImagePair {
+	if referencePoints := i.Georeference().ReferencePoints; len(referencePoints) > 0 {
+		return referencePoints
+	}
+
+	minPx, maxPx := i.PixelBounds()[0], i.PixelBounds()[1]
+	var referencePoints []MapImagePair
+	for _, pixel := range []LatLng{
+		minPx,
+		{Lat: minPx.Lat, Lng: maxPx.Lng},
+		maxPx,
+		{Lat: maxPx.Lat, Lng: minPx.Lng},
+		{Lat: (minPx.Lat + maxPx.Lat) / 2, Lng: (minPx.Lng + maxPx.Lng) / 2},
+	} {
+		referencePoints = append(referencePoints, MapImagePair{Geographic: i.GeoFromPixel(pixel), Pixel: pixel})
+	}
+	return referencePoints
+}
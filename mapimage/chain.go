@@ -0,0 +1,80 @@
+package mapimage
+
+import (
+	"math"
+)
+
+// ChainedTransformation applies each of its transformations in turn, e.g. to
+// fit a transformation in some other coordinate system than lat/lng
+type ChainedTransformation struct {
+	steps []Transformation
+}
+
+func NewChainedTransformation(steps ...Transformation) ChainedTransformation {
+	return ChainedTransformation{steps}
+}
+
+// Inverse undoes each of the steps, in reverse order
+func (t *ChainedTransformation) Inverse() Transformation {
+	steps := make([]Transformation, len(t.steps))
+	for i, step := range t.steps {
+		steps[len(t.steps)-1-i] = step.Inverse()
+	}
+	inverse := NewChainedTransformation(steps...)
+	return &inverse
+}
+
+func (t *ChainedTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *ChainedTransformation) Projects(points ...Point) (results []Point) {
+	results = points
+	for _, step := range t.steps {
+		results = step.Projects(results...)
+	}
+	return
+}
+
+// WebMercatorTransformation projects lat/lng (in degrees) to (conformal) Web
+// Mercator metres
+type WebMercatorTransformation struct {
+	inverse bool
+}
+
+func NewWebMercatorTransformation() WebMercatorTransformation {
+	return WebMercatorTransformation{inverse: false}
+}
+
+func NewInverseWebMercatorTransformation() WebMercatorTransformation {
+	return WebMercatorTransformation{inverse: true}
+}
+
+func (t *WebMercatorTransformation) Inverse() Transformation {
+	return &WebMercatorTransformation{inverse: !t.inverse}
+}
+
+func (t *WebMercatorTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *WebMercatorTransformation) Projects(points ...Point) (results []Point) {
+	for _, p := range points {
+		if t.inverse {
+			lat, lng := webMercatorToLatLon(p.Lng, p.Lat)
+			results = append(results, Point{Lat: lat, Lng: lng})
+		} else {
+			mx, my := LatLonToMeters(p.Lat, p.Lng)
+			results = append(results, PointEastingNorthing(mx, my))
+		}
+	}
+	return
+}
+
+// webMercatorToLatLon is the inverse of LatLonToMeters (NB: MetersToLatLon
+// flips the latitude, to suit the y axis of the tiles)
+func webMercatorToLatLon(mx, my float64) (lat, lon float64) {
+	lat = DegFromRad(2*math.Atan(math.Exp(my/earthRadius)) - math.Pi/2)
+	lon = mx / originShift * 180.0
+	return
+}
@@ -0,0 +1,272 @@
+package mapimage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A chunk store is a memory mapped file of an image's RGBA pixels in square
+// chunks: chunkStoreMagic, the width, height and chunk size (little endian
+// uint32s), then the chunks (padded at the edges) a row at a time
+
+const chunkStoreMagic = "MICS"
+
+const (
+	chunkStoreHeader = 16
+	chunkSize        = 256
+)
+
+type chunkStore struct {
+	width, height, chunkSize int
+	// How many chunks there are across a row
+	chunksX int
+	data    []byte
+}
+
+// openChunkStore memory maps the chunk store in the file
+func openChunkStore(filename string) (*chunkStore, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var header [chunkStoreHeader]byte
+	if _, err := f.ReadAt(header[:], 0); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != chunkStoreMagic {
+		return nil, fmt.Errorf("%v is not a chunk store", filename)
+	}
+	s := chunkStore{
+		width:     int(binary.LittleEndian.Uint32(header[4:])),
+		height:    int(binary.LittleEndian.Uint32(header[8:])),
+		chunkSize: int(binary.LittleEndian.Uint32(header[12:])),
+	}
+	if s.chunkSize == 0 {
+		return nil, fmt.Errorf("%v has no chunk size", filename)
+	}
+	s.chunksX = (s.width + s.chunkSize - 1) / s.chunkSize
+	chunksY := (s.height + s.chunkSize - 1) / s.chunkSize
+
+	size := chunkStoreHeader + s.chunksX*chunksY*s.chunkSize*s.chunkSize*4
+	if info, err := f.Stat(); err != nil {
+		return nil, err
+	} else if info.Size() != int64(size) {
+		return nil, fmt.Errorf("%v is %v bytes, not %v (cut short?)", filename, info.Size(), size)
+	}
+	if s.data, err = mapFile(f, size); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// writeChunkStore saves src (reading it a chunk at a time, and checking its
+// Err method if it has one) as a chunk store, via a temporary file
+func writeChunkStore(filename string, src image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return err
+	}
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	bounds := src.Bounds()
+	w := bufio.NewWriter(f)
+	var header [chunkStoreHeader]byte
+	copy(header[:], chunkStoreMagic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(bounds.Dx()))
+	binary.LittleEndian.PutUint32(header[8:], uint32(bounds.Dy()))
+	binary.LittleEndian.PutUint32(header[12:], chunkSize)
+	w.Write(header[:])
+
+	chunk := image.NewRGBA(image.Rect(0, 0, chunkSize, chunkSize))
+	for cy := 0; cy < bounds.Dy(); cy += chunkSize {
+		for cx := 0; cx < bounds.Dx(); cx += chunkSize {
+			draw.Draw(chunk, chunk.Bounds(), image.Transparent, image.ZP, draw.Src)
+			draw.Draw(chunk, chunk.Bounds(), src, bounds.Min.Add(image.Pt(cx, cy)), draw.Src)
+			if _, err := w.Write(chunk.Pix); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if e, ok := src.(interface{ Err() error }); ok && e.Err() != nil {
+		return e.Err()
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+func (s *chunkStore) Close() error {
+	err := unmapFile(s.data)
+	s.data = nil
+	return err
+}
+
+func (s *chunkStore) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (s *chunkStore) Bounds() image.Rectangle {
+	return image.Rect(0, 0, s.width, s.height)
+}
+
+func (s *chunkStore) At(x, y int) color.Color {
+	return s.RGBAAt(x, y)
+}
+
+func (s *chunkStore) RGBAAt(x, y int) color.RGBA {
+	if x < 0 || y < 0 || x >= s.width || y >= s.height {
+		return color.RGBA{}
+	}
+	chunk := (y/s.chunkSize)*s.chunksX + x/s.chunkSize
+	i := chunkStoreHeader + (chunk*s.chunkSize*s.chunkSize+(y%s.chunkSize)*s.chunkSize+x%s.chunkSize)*4
+	p := s.data[i : i+4 : i+4]
+	return color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]}
+}
+
+// loadChunkStore opens the chunk store in the file, or (re)builds it from
+// src when it isn't there or is older than modTime. The image from src is
+// closed after, when it is an io.Closer (e.g. a temporary file).
+func loadChunkStore(filename string, modTime time.Time, src func() (image.Image, error)) (*chunkStore, error) {
+	if info, err := os.Stat(filename); err == nil && !info.ModTime().Before(modTime) {
+		s, err := openChunkStore(filename)
+		if err == nil {
+			return s, nil
+		}
+		log.Println("chunk store", err)
+	}
+
+	img, err := src()
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := img.(io.Closer); ok {
+		defer c.Close()
+	}
+	log.Printf("Writing %v\n", filename)
+	if err := writeChunkStore(filename, img); err != nil {
+		return nil, err
+	}
+	return openChunkStore(filename)
+}
+
+// loadChunkPyramid opens (or builds, from the level before) dir/pixels.raw
+// and overviewDir/{n}.raw for each overview level n
+func loadChunkPyramid(dir, overviewDir string, modTime time.Time, build func(level int, previous *chunkStore) (image.Image, error)) (*chunkStore, []image.Image, error) {
+	store, err := loadChunkStore(filepath.Join(dir, "pixels.raw"), modTime, func() (image.Image, error) {
+		return build(0, nil)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if store.width == 0 || store.height == 0 {
+		return nil, nil, errors.New("chunk store: empty image")
+	}
+
+	var overviews []image.Image
+	if overviewDir != "" {
+		previous := store
+		for level := 1; level <= overviewLevels(store.width, store.height); level++ {
+			filename := filepath.Join(overviewDir, fmt.Sprintf("%d.raw", level))
+			overview, err := loadChunkStore(filename, modTime, func() (image.Image, error) {
+				return build(level, previous)
+			})
+			if err != nil {
+				log.Println("overview", level, err)
+				break
+			}
+			overviews = append(overviews, overview)
+			previous = overview
+		}
+	}
+	return store, overviews, nil
+}
+
+func init() {
+	RegisterBackend("chunked", NewChunkedImageInfo)
+}
+
+// MaxDecodeMemory is the most (in bytes, as RGBA) that a non-PNG image is
+// decoded into in memory, for a chunk store
+var MaxDecodeMemory int64 = 1 << 30
+
+// decodeForChunks is the image to write to a chunk store, i.e. a PNG as it is
+// read or, up to MaxDecodeMemory, any image decoded into memory
+func decodeForChunks(contents io.ReadSeeker) (image.Image, error) {
+	if _, err := contents.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	if s, err := newPNGStream(contents); err == nil {
+		return s, nil
+	}
+
+	contents.Seek(0, 0)
+	config, format, err := image.DecodeConfig(contents)
+	if err != nil {
+		return nil, err
+	}
+	if size := 4 * int64(config.Width) * int64(config.Height); size > MaxDecodeMemory {
+		return nil, fmt.Errorf("chunk store: the %vx%v %v image needs %v MB to decode, over the %v MB limit (save it as a non-interlaced PNG, which is read a band at a time)",
+			config.Width, config.Height, format, size>>20, MaxDecodeMemory>>20)
+	}
+	contents.Seek(0, 0)
+	img, _, err := image.Decode(contents)
+	return img, err
+}
+
+// NewChunkedImageInfo is a MapImage for images too big for memory, decoded
+// (the once) into chunk stores in dir and the OverviewDir
+func NewChunkedImageInfo(
+	id,
+	text string,
+	georef Georeference,
+	options TileOptions,
+	contents *os.File,
+	dir string) (MapImage, error) {
+	var modTime time.Time
+	if info, err := contents.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+
+	store, overviews, err := loadChunkPyramid(dir, options.OverviewDir, modTime, func(level int, previous *chunkStore) (image.Image, error) {
+		if level > 0 {
+			return halfImage{previous}, nil
+		}
+		return decodeForChunks(contents)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	i := goImage{
+		id:        id,
+		text:      text,
+		georef:    georef,
+		options:   options,
+		contents:  contents,
+		image:     store,
+		overviews: overviews,
+	}
+	i.minZoom = calculateMinZoom(&i)
+	i.maxZoom = calculateMaxZoom(&i)
+	return &i, nil
+}
@@ -0,0 +1,109 @@
+package mapimage
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChunkStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Not a whole number of chunks either way, nor starting at 0, 0
+	src := image.NewNRGBA(image.Rect(10, 20, 310, 290))
+	for y := 20; y < 290; y++ {
+		for x := 10; x < 310; x++ {
+			src.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x * y), A: uint8(128 + x%128)})
+		}
+	}
+
+	filename := filepath.Join(dir, "pixels.raw")
+	if err := writeChunkStore(filename, src); err != nil {
+		t.Fatal(err)
+	}
+	sut, err := openChunkStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sut.Close()
+
+	if sut.Bounds() != image.Rect(0, 0, 300, 270) {
+		t.Fatalf("incorrect bounds, got: %v.", sut.Bounds())
+	}
+	for y := 0; y < 270; y++ {
+		for x := 0; x < 300; x++ {
+			expected := color.RGBAModel.Convert(src.At(x+10, y+20))
+			if result := sut.At(x, y); result != expected {
+				t.Fatalf("incorrect for %v,%v, got: %v, want: %v.", x, y, result, expected)
+			}
+		}
+	}
+	if result := sut.At(300, 0); result != (color.RGBA{}) {
+		t.Errorf("incorrect outside, got: %v.", result)
+	}
+
+	// Cut short
+	buf, _ := ioutil.ReadFile(filename)
+	ioutil.WriteFile(filename, buf[:len(buf)-1], 0666)
+	if _, err := openChunkStore(filename); err == nil {
+		t.Error("expected an error for a truncated store")
+	}
+	ioutil.WriteFile(filename, bytes.Repeat([]byte{0}, 100), 0666)
+	if _, err := openChunkStore(filename); err == nil {
+		t.Error("expected an error for a file that isn't a store")
+	}
+}
+
+func TestChunkedImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	original := testMapImage(t, TileOptions{})
+	f := original.ImageContent().(*os.File)
+
+	options := TileOptions{OverviewDir: filepath.Join(dir, "overviews")}
+	sut, err := NewChunkedImageInfo("chunked", "Chunked", original.Georeference(), options, f, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sut.PixelBounds() != original.PixelBounds() || sut.MinZoom() != original.MinZoom() || sut.MaxZoom() != original.MaxZoom() {
+		t.Errorf("incorrect, got: %v %v-%v, want: %v %v-%v.", sut.PixelBounds(), sut.MinZoom(), sut.MaxZoom(),
+			original.PixelBounds(), original.MinZoom(), original.MaxZoom())
+	}
+	if len(sut.(*goImage).overviews) != 2 {
+		t.Errorf("incorrect, got: %v overviews.", len(sut.(*goImage).overviews))
+	}
+
+	// Zoomed in, the tiles are the same as from the image in memory
+	zoom := int64(original.MaxZoom())
+	x, y := cornerTile(original, zoom)
+	expected, result := bytes.Buffer{}, bytes.Buffer{}
+	expected.ReadFrom(original.MapTile(zoom, x, y, PNGTile))
+	result.ReadFrom(sut.MapTile(zoom, x, y, PNGTile))
+	if !bytes.Equal(expected.Bytes(), result.Bytes()) {
+		t.Error("incorrect, the tiles are different")
+	}
+
+	// The stores are reused the next time
+	info, err := os.Stat(filepath.Join(dir, "pixels.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewChunkedImageInfo("chunked", "Chunked", original.Georeference(), options, f, dir); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.Stat(filepath.Join(dir, "pixels.raw")); !again.ModTime().Equal(info.ModTime()) {
+		t.Error("expected the chunk store to be reused")
+	}
+}
@@ -0,0 +1,455 @@
+package mapimage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// COGOptions are the choices for how a map image is exported as a Cloud
+// Optimized GeoTIFF
+type COGOptions struct {
+	// Warp the image to Web Mercator (EPSG:3857), i.e. make it out of the map
+	// tiles, rather than keeping the pixels of the image itself
+	WebMercator bool
+}
+
+// The TIFF tags written for each image (full resolution or overview) of a COG
+const (
+	tiffNewSubfileType    = 254
+	tiffBitsPerSample     = 258
+	tiffCompression       = 259
+	tiffPhotometric       = 262
+	tiffSamplesPerPixel   = 277
+	tiffPlanarConfig      = 284
+	tiffTileWidth         = 322
+	tiffTileLength        = 323
+	tiffTileOffsets       = 324
+	tiffTileByteCounts    = 325
+	tiffExtraSamples      = 338
+	tiffCompressionZlib   = 8
+	tiffPhotometricRGB    = 2
+	tiffUnassociatedAlpha = 2
+)
+
+// cogLevel is the full resolution image, or one of its overviews, cut into
+// tiles (compressed, row by row)
+type cogLevel struct {
+	width, height int
+	// Where its tiles start in the spool, and their sizes
+	start     int64
+	tileSizes []uint32
+}
+
+// cogSpool is a temporary file that the tiles are written to as they are
+// made, since in the COG they come after all of the IFDs
+type cogSpool struct {
+	f      *os.File
+	size   int64
+	levels []cogLevel
+}
+
+func (s *cogSpool) addLevel(width, height int) {
+	s.levels = append(s.levels, cogLevel{width: width, height: height, start: s.size})
+}
+
+// addTile adds the tile of the image with its top left corner at min to the
+// last level
+func (s *cogSpool) addTile(img image.Image, min image.Point) error {
+	tile := cogTile(img, min)
+	if _, err := s.f.Write(tile); err != nil {
+		return fmt.Errorf("cog: %v", err)
+	}
+	level := &s.levels[len(s.levels)-1]
+	level.tileSizes = append(level.tileSizes, uint32(len(tile)))
+	s.size += int64(len(tile))
+	return nil
+}
+
+// levelTiles are the (compressed) tiles of the level'th image
+func (s *cogSpool) levelTiles(l int) io.Reader {
+	end := s.size
+	if l+1 < len(s.levels) {
+		end = s.levels[l+1].start
+	}
+	return io.NewSectionReader(s.f, s.levels[l].start, end-s.levels[l].start)
+}
+
+// COG is a map image as a Cloud Optimized GeoTIFF, whose tiles are kept in a
+// temporary file until it is closed
+type COG struct {
+	spool   cogSpool
+	geoTags []tiffTag
+}
+
+// NewCOG makes the tiles of the COG, a tile at a time
+func NewCOG(i MapImage, options COGOptions) (*COG, error) {
+	f, err := ioutil.TempFile("", "cog")
+	if err != nil {
+		return nil, err
+	}
+	c := COG{spool: cogSpool{f: f}}
+	if options.WebMercator {
+		c.geoTags, err = webMercatorCOGLevels(&c.spool, i)
+	} else {
+		c.geoTags, err = nativeCOGLevels(&c.spool, i)
+	}
+	if err == nil && c.Size() > math.MaxUint32 {
+		err = errors.New("cog: too big for a (non BigTIFF) TIFF")
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Close removes the temporary file of the tiles
+func (c *COG) Close() error {
+	c.spool.f.Close()
+	return os.Remove(c.spool.f.Name())
+}
+
+// WriteCOG writes the map image out as a COG
+func WriteCOG(w io.Writer, i MapImage, options COGOptions) error {
+	c, err := NewCOG(i, options)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Write(w)
+}
+
+// pixelLevels is a MapImage with its pixels to hand: the image itself, then
+// any of its overviews
+type pixelLevels interface {
+	pixelLevels() []image.Image
+}
+
+// nativeCOGLevels are the image's own pixels, with its overviews (or, for
+// those it doesn't have, it halved)
+func nativeCOGLevels(spool *cogSpool, i MapImage) ([]tiffTag, error) {
+	var pixels []image.Image
+	if p, ok := i.(pixelLevels); ok {
+		pixels = p.pixelLevels()
+	}
+	if len(pixels) == 0 {
+		return nil, fmt.Errorf("cog: %v has no pixels to export", i.Id())
+	}
+
+	georef := i.Georeference()
+	crs, code := georef.crs, epsgCode(georef.crs)
+	if code == 0 {
+		crs, code = LatLngCRS, 4326
+	}
+	wf, err := fitWorldFile(georef, crs, i.PixelBounds())
+	if err != nil {
+		return nil, err
+	}
+
+	img := pixels[0]
+	for level := 0; ; level++ {
+		if level < len(pixels) {
+			img = pixels[level]
+		}
+		if err := cogTiles(spool, img); err != nil {
+			return nil, err
+		}
+		b := img.Bounds()
+		if b.Dx() <= int(tileSize) && b.Dy() <= int(tileSize) {
+			break
+		}
+		if level+1 >= len(pixels) {
+			img = halve(img)
+		}
+	}
+	return geoTIFFTags(wf, code, crs.Projected()), nil
+}
+
+// webMercatorCOGLevels are the map tiles, from the maximum zoom down to where
+// the image fits within 2x2 tiles, each level covering the same tiles
+func webMercatorCOGLevels(spool *cogSpool, i MapImage) ([]tiffTag, error) {
+	bounds := i.GeoBounds()
+	tileRange := func(zoom int64) (x0, y0, x1, y1 int64) {
+		size := Resolution(zoom) * float64(tileSize)
+		tile := func(ll LatLng) (int64, int64) {
+			mx, my := LatLonToMeters(ll.Lat, ll.Lng)
+			return int64(math.Floor((mx + originShift) / size)), int64(math.Floor((originShift - my) / size))
+		}
+		x0, y0 = tile(bounds[0])
+		x1, y1 = tile(bounds[1])
+		return
+	}
+
+	maxZoom := int64(i.MaxZoom())
+	top := maxZoom
+	for ; top > 0; top-- {
+		x0, y0, x1, y1 := tileRange(top)
+		if x1-x0 < 2 && y1-y0 < 2 {
+			break
+		}
+	}
+	x0, y0, x1, y1 := tileRange(top)
+
+	for zoom := maxZoom; zoom >= top; zoom-- {
+		scale := int64(1) << uint(zoom-top)
+		spool.addLevel(int((x1-x0+1)*scale*tileSize), int((y1-y0+1)*scale*tileSize))
+		for y := y0 * scale; y < (y1+1)*scale; y++ {
+			for x := x0 * scale; x < (x1+1)*scale; x++ {
+				tile, err := png.Decode(i.MapTile(zoom, x, y, PNGTile))
+				if err != nil {
+					return nil, err
+				}
+				if err := spool.addTile(tile, tile.Bounds().Min); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	scale := int64(1) << uint(maxZoom-top)
+	res := Resolution(maxZoom)
+	wf := WorldFile{
+		A: res,
+		E: -res,
+		C: float64(x0*scale*tileSize)*res - originShift + res/2,
+		F: originShift - float64(y0*scale*tileSize)*res - res/2,
+	}
+	return geoTIFFTags(wf, 3857, true), nil
+}
+
+// cogTiles cuts the image into tiles, as the next level
+func cogTiles(spool *cogSpool, img image.Image) error {
+	b := img.Bounds()
+	spool.addLevel(b.Dx(), b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y += int(tileSize) {
+		for x := b.Min.X; x < b.Max.X; x += int(tileSize) {
+			if err := spool.addTile(img, image.Pt(x, y)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cogTile is the (compressed) tile of the image with its top left corner at
+// min, which is padded with transparent pixels where it goes past the edges
+func cogTile(img image.Image, min image.Point) []byte {
+	tile := image.NewNRGBA(image.Rect(0, 0, int(tileSize), int(tileSize)))
+	draw.Draw(tile, tile.Bounds(), img, min, draw.Src)
+
+	var buf bytes.Buffer
+	z := zlib.NewWriter(&buf)
+	z.Write(tile.Pix)
+	z.Close()
+	return buf.Bytes()
+}
+
+// epsgCode is the EPSG code of the CRS, or 0 when it doesn't have one (e.g.
+// it is a PROJ string, or has been given a datum)
+func epsgCode(crs CRS) int {
+	if !strings.HasPrefix(crs.Name, "EPSG:") {
+		return 0
+	}
+	code, err := strconv.Atoi(crs.Name[len("EPSG:"):])
+	if err != nil {
+		return 0
+	}
+	return code
+}
+
+// geoTIFFTags are the tags for an affine georeference (as a world file) in
+// the CRS with the EPSG code
+func geoTIFFTags(wf WorldFile, code int, projected bool) []tiffTag {
+	var tags []tiffTag
+
+	// The world file is for the centre of the top left pixel, the tags for
+	// its corner (PixelIsArea)
+	x0, y0 := wf.C-(wf.A+wf.B)/2, wf.F-(wf.D+wf.E)/2
+	// (A fitted world file is never exactly axis aligned)
+	if math.Abs(wf.B) < 1e-9*math.Abs(wf.A) && math.Abs(wf.D) < 1e-9*math.Abs(wf.E) {
+		tags = append(tags,
+			doublesTag(tiffModelPixelScale, wf.A, -wf.E, 0),
+			doublesTag(tiffModelTiepoint, 0, 0, 0, x0, y0, 0))
+	} else {
+		tags = append(tags, doublesTag(tiffModelTransformation,
+			wf.A, wf.B, 0, x0,
+			wf.D, wf.E, 0, y0,
+			0, 0, 0, 0,
+			0, 0, 0, 1))
+	}
+
+	model, typeKey := uint16(geoModelGeographic), uint16(geoKeyGeographicType)
+	if projected {
+		model, typeKey = geoModelProjected, geoKeyProjectedType
+	}
+	return append(tags, shortsTag(tiffGeoKeyDirectory,
+		1, 1, 0, 3,
+		geoKeyModelType, 0, 1, model,
+		geoKeyRasterType, 0, 1, 1,
+		typeKey, 0, 1, uint16(code)))
+}
+
+// tiffTag is a TIFF tag with its values already encoded
+type tiffTag struct {
+	tag, datatype uint16
+	count         int
+	data          []byte
+}
+
+var tiffOrder = binary.LittleEndian
+
+func shortsTag(tag uint16, values ...uint16) tiffTag {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		tiffOrder.PutUint16(data[2*i:], v)
+	}
+	return tiffTag{tag: tag, datatype: 3, count: len(values), data: data}
+}
+
+func longsTag(tag uint16, values ...uint32) tiffTag {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		tiffOrder.PutUint32(data[4*i:], v)
+	}
+	return tiffTag{tag: tag, datatype: 4, count: len(values), data: data}
+}
+
+func doublesTag(tag uint16, values ...float64) tiffTag {
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		tiffOrder.PutUint64(data[8*i:], math.Float64bits(v))
+	}
+	return tiffTag{tag: tag, datatype: 12, count: len(values), data: data}
+}
+
+// cogTags are the tags for the level'th image, whose tiles are at offsets.
+// Only the full resolution image has the geoTags.
+func cogTags(l int, level cogLevel, offsets []uint32, geoTags []tiffTag) []tiffTag {
+	tags := []tiffTag{
+		longsTag(tiffImageWidth, uint32(level.width)),
+		longsTag(tiffImageLength, uint32(level.height)),
+		shortsTag(tiffBitsPerSample, 8, 8, 8, 8),
+		shortsTag(tiffCompression, tiffCompressionZlib),
+		shortsTag(tiffPhotometric, tiffPhotometricRGB),
+		shortsTag(tiffSamplesPerPixel, 4),
+		shortsTag(tiffPlanarConfig, 1),
+		shortsTag(tiffTileWidth, uint16(tileSize)),
+		shortsTag(tiffTileLength, uint16(tileSize)),
+		longsTag(tiffTileOffsets, offsets...),
+		longsTag(tiffTileByteCounts, level.tileSizes...),
+		shortsTag(tiffExtraSamples, tiffUnassociatedAlpha),
+	}
+	if l == 0 {
+		tags = append(tags, geoTags...)
+	} else {
+		// (A reduced resolution version of the first image)
+		tags = append(tags, longsTag(tiffNewSubfileType, 1))
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].tag < tags[j].tag })
+	return tags
+}
+
+// ifdSize is the size of the IFD for the tags, including the values that
+// don't fit within it
+func ifdSize(tags []tiffTag) int64 {
+	size := int64(2 + 12*len(tags) + 4)
+	for _, t := range tags {
+		if len(t.data) > 4 {
+			size += int64(len(t.data) + len(t.data)%2)
+		}
+	}
+	return size
+}
+
+// layout is where the IFDs go, then the tiles, from the smallest overview up
+func (c *COG) layout() (ifdOffsets []int64, tileOffsets [][]uint32, size int64) {
+	levels := c.spool.levels
+	ifdOffsets = make([]int64, len(levels))
+	pos := int64(8)
+	for l, level := range levels {
+		ifdOffsets[l] = pos
+		pos += ifdSize(cogTags(l, level, make([]uint32, len(level.tileSizes)), c.geoTags))
+	}
+	tileOffsets = make([][]uint32, len(levels))
+	for l := len(levels) - 1; l >= 0; l-- {
+		for _, n := range levels[l].tileSizes {
+			tileOffsets[l] = append(tileOffsets[l], uint32(pos))
+			pos += int64(n)
+		}
+	}
+	return ifdOffsets, tileOffsets, pos
+}
+
+// Size is the size of the file, in bytes
+func (c *COG) Size() int64 {
+	_, _, size := c.layout()
+	return size
+}
+
+// Write writes the file out, copying the tiles from the temporary file
+func (c *COG) Write(w io.Writer) error {
+	levels := c.spool.levels
+	ifdOffsets, tileOffsets, _ := c.layout()
+
+	out := bufio.NewWriter(w)
+	header := make([]byte, 8)
+	copy(header, "II")
+	tiffOrder.PutUint16(header[2:], 42)
+	tiffOrder.PutUint32(header[4:], uint32(ifdOffsets[0]))
+	out.Write(header)
+
+	for l, level := range levels {
+		tags := cogTags(l, level, tileOffsets[l], c.geoTags)
+		next := uint32(0)
+		if l+1 < len(levels) {
+			next = uint32(ifdOffsets[l+1])
+		}
+
+		ifd := make([]byte, 2+12*len(tags)+4)
+		var values []byte
+		valuesOffset := ifdOffsets[l] + int64(len(ifd))
+		tiffOrder.PutUint16(ifd, uint16(len(tags)))
+		for i, t := range tags {
+			e := ifd[2+12*i:]
+			tiffOrder.PutUint16(e[0:], t.tag)
+			tiffOrder.PutUint16(e[2:], t.datatype)
+			tiffOrder.PutUint32(e[4:], uint32(t.count))
+			if len(t.data) <= 4 {
+				copy(e[8:], t.data)
+			} else {
+				tiffOrder.PutUint32(e[8:], uint32(valuesOffset+int64(len(values))))
+				values = append(values, t.data...)
+				if len(t.data)%2 != 0 {
+					values = append(values, 0)
+				}
+			}
+		}
+		tiffOrder.PutUint32(ifd[len(ifd)-4:], next)
+		out.Write(ifd)
+		out.Write(values)
+	}
+
+	for l := len(levels) - 1; l >= 0; l-- {
+		if _, err := io.Copy(out, c.spool.levelTiles(l)); err != nil {
+			return fmt.Errorf("cog: %v", err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("cog: %v", err)
+	}
+	return nil
+}
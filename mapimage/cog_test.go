@@ -0,0 +1,139 @@
+package mapimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"gonum.org/v1/gonum/floats"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// cogIFDs counts the images in a TIFF, by following the chain of IFDs
+func cogIFDs(file []byte) (n int) {
+	for offset := binary.LittleEndian.Uint32(file[4:]); offset != 0; n++ {
+		entries := binary.LittleEndian.Uint16(file[offset:])
+		offset = binary.LittleEndian.Uint32(file[offset+2+12*uint32(entries):])
+	}
+	return
+}
+
+func TestWriteCOG(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The same, whether the pixels are in memory or in chunk stores
+	original := testMapImage(t, TileOptions{})
+	options := TileOptions{OverviewDir: filepath.Join(dir, "overviews")}
+	chunked, err := NewChunkedImageInfo("chunked", "Chunked", original.Georeference(), options, original.ImageContent().(*os.File), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCOG(t, original)
+	checkCOG(t, FilesystemCachedImage(chunked))
+
+	if _, err := NewCOG(newAffineTestImage(0, 1, 1, 0), COGOptions{}); err == nil {
+		t.Error("expected an error for an image without pixels")
+	}
+}
+
+func checkCOG(t *testing.T, sut MapImage) {
+	cog, err := NewCOG(sut, COGOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cog.Close()
+	var buf bytes.Buffer
+	if err := cog.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	if int64(len(file)) != cog.Size() {
+		t.Errorf("incorrect size for %v, got: %v, want: %v.", sut.Id(), len(file), cog.Size())
+	}
+
+	// The full resolution image and two overviews (300x200 and 150x100)
+	if n := cogIFDs(file); n != 3 {
+		t.Errorf("incorrect number of images for %v, got: %v, want: 3.", sut.Id(), n)
+	}
+
+	g, err := ReadGeoTIFF(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crs, err := g.CRS(); err != nil || crs.Name != "EPSG:32635" {
+		t.Errorf("incorrect CRS, got: %v (%v), want: EPSG:32635.", crs.Name, err)
+	}
+	expected := []float64{0, 0, 0, 453120, 4455800, 0}
+	if !floats.EqualApprox(g.Tiepoints, expected, 1e-9) || !floats.EqualApprox(g.PixelScale, []float64{2, 2, 0}, 1e-9) {
+		t.Errorf("incorrect georeference, got: %v %v, want: %v [2 2 0].", g.Tiepoints, g.PixelScale, expected)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "tiff" || img.Bounds() != image.Rect(0, 0, 600, 400) {
+		t.Fatalf("incorrect image, got: %v %v.", format, img.Bounds())
+	}
+	for _, pt := range []image.Point{{0, 0}, {255, 255}, {256, 256}, {599, 399}, {300, 17}} {
+		result := color.NRGBAModel.Convert(img.At(pt.X, pt.Y))
+		want := color.NRGBA{R: uint8(pt.X), G: uint8(pt.Y), B: uint8(pt.X + pt.Y), A: 255}
+		if result != want {
+			t.Errorf("incorrect for %v of %v, got: %v, want: %v.", pt, sut.Id(), result, want)
+		}
+	}
+}
+
+func TestWriteWebMercatorCOG(t *testing.T) {
+	sut := testMapImage(t, TileOptions{})
+
+	var buf bytes.Buffer
+	if err := WriteCOG(&buf, sut, COGOptions{WebMercator: true}); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+
+	g, err := ReadGeoTIFF(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crs, err := g.CRS(); err != nil || crs.Name != "EPSG:3857" {
+		t.Errorf("incorrect CRS, got: %v (%v), want: EPSG:3857.", crs.Name, err)
+	}
+	zoom := int64(sut.MaxZoom())
+	res := Resolution(zoom)
+	if !floats.EqualWithinAbs(g.PixelScale[0], res, 1e-9) {
+		t.Errorf("incorrect pixel size, got: %v, want: %v.", g.PixelScale[0], res)
+	}
+
+	// The first tile is the map tile at the tie point
+	img, _, err := image.Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx()%256 != 0 || img.Bounds().Dy()%256 != 0 {
+		t.Errorf("incorrect size, got: %v.", img.Bounds())
+	}
+	x := int64(math.Round((g.Tiepoints[3] + originShift) / (res * 256)))
+	y := int64(math.Round((originShift - g.Tiepoints[4]) / (res * 256)))
+	tile, err := png.Decode(sut.MapTile(zoom, x, y, PNGTile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pt := range []image.Point{{0, 0}, {100, 200}, {255, 255}} {
+		result := color.NRGBAModel.Convert(img.At(pt.X, pt.Y))
+		want := color.NRGBAModel.Convert(tile.At(pt.X, pt.Y))
+		if result != want {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", pt, result, want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package mapimage
 
 import (
+	"errors"
 	"gonum.org/v1/gonum/mat"
 	"math"
 )
@@ -16,19 +17,26 @@ func NewConformalTransformation(k, theta, Tx, Ty float64) ConformalTransformatio
 	return ConformalTransformation{trans}
 }
 
+// Any more than 2 points are fitted in the least squares sense
 func NewConformalTransformationFromPoints(standardPoints []Point, localPoints []Point) (ConformalTransformation, error) {
-	n1, e1 := standardPoints[0].Lat, standardPoints[0].Lng
-	n2, e2 := standardPoints[1].Lat, standardPoints[1].Lng
-
-	E := mat.NewDense(4, 1, []float64{e1, n1, e2, n2})
-	y1, x1 := localPoints[0].Lat, localPoints[0].Lng
-	y2, x2 := localPoints[1].Lat, localPoints[1].Lng
-	X := mat.NewDense(4, 4, []float64{
-		x1, -y1, 1, 0,
-		y1, x1, 0, 1,
-		x2, -y2, 1, 0,
-		y2, x2, 0, 1,
-	})
+	if len(standardPoints) != len(localPoints) {
+		return ConformalTransformation{}, errors.New("conformal: mismatched number of points")
+	}
+	if len(standardPoints) < 2 {
+		return ConformalTransformation{}, errors.New("conformal: at least 2 points are required")
+	}
+
+	n := len(standardPoints)
+	E := mat.NewDense(2*n, 1, nil)
+	X := mat.NewDense(2*n, 4, nil)
+	for i := range standardPoints {
+		E.Set(2*i, 0, standardPoints[i].Lng)
+		E.Set(2*i+1, 0, standardPoints[i].Lat)
+
+		y, x := localPoints[i].Lat, localPoints[i].Lng
+		X.SetRow(2*i, []float64{x, -y, 1, 0})
+		X.SetRow(2*i+1, []float64{y, x, 0, 1})
+	}
 
 	var trans mat.Dense
 	err := trans.Solve(X, E)
@@ -43,6 +51,18 @@ func NewConformalTransformationFromPoints(standardPoints []Point, localPoints []
 	return ConformalTransformation{&trans}, nil
 }
 
+func (t *ConformalTransformation) Inverse() Transformation {
+	a, b, Tx, Ty := t.trans.At(0, 0), t.trans.At(1, 0), t.trans.At(2, 0), t.trans.At(3, 0)
+
+	// Scaling by 1/k and rotating by -theta, i.e.
+	//   a' = cos(-theta)/k = a/k^2
+	//   b' = sin(-theta)/k = -b/k^2
+	k2 := a*a + b*b
+	ia, ib := a/k2, -b/k2
+	trans := mat.NewDense(4, 1, []float64{ia, ib, -(ia*Tx - ib*Ty), -(ib*Tx + ia*Ty)})
+	return &ConformalTransformation{trans}
+}
+
 func (t *ConformalTransformation) Project(p Point) Point {
 	return t.Projects(p)[0]
 }
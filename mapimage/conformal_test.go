@@ -65,6 +65,71 @@ func TestBuildConformalTransformationFromPoints(t *testing.T) {
 	}
 }
 
+func TestBuildConformalTransformationFromManyPoints(t *testing.T) {
+	k, theta, Tx, Ty := 1.25, RadFromDeg(-30), 164618.06, 1383016.332
+	params := NewConformalTransformation(k, theta, Tx, Ty)
+
+	localPoints := []Point{
+		PointXY(100000, 200000),
+		PointXY(104000, 204000),
+		PointXY(104000, 200000),
+		PointXY(100000, 204000)}
+	standardPoints := params.Projects(localPoints...)
+
+	sut, err := NewConformalTransformationFromPoints(standardPoints, localPoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	residuals := NewResiduals(&sut, standardPoints, localPoints)
+	if residuals.RMSE > 0.000001 {
+		t.Errorf("RMSE too large, got: %v", residuals.RMSE)
+	}
+
+	result := sut.Project(PointXY(102000, 202000))
+	expect := params.Project(PointXY(102000, 202000))
+	if !floats.EqualWithinRel(result.Lat, expect.Lat, 0.00000001) ||
+		!floats.EqualWithinRel(result.Lng, expect.Lng, 0.00000001) {
+		t.Errorf("Projection incorrect, got: %v, want: %v.", result, expect)
+	}
+}
+
+func TestConformalModelForMapImages(t *testing.T) {
+	// A map that is 5 metres/pixel (in Web Mercator) and turned 20 degrees
+	k, theta := 5.0, RadFromDeg(20)
+	mx, my := LatLonToMeters(40.3, 26.2)
+	onGround := NewConformalTransformation(k, theta, mx, my)
+	fromMercator := NewInverseWebMercatorTransformation()
+	expectGeo := func(pixel LatLng) LatLng {
+		m := onGround.Project(PointXY(pixel.Lng, -pixel.Lat))
+		return LatLng(fromMercator.Project(m))
+	}
+
+	pixels := []LatLng{{Lat: 100, Lng: 200}, {Lat: 9000, Lng: 7000}}
+	var referencePoints []MapImagePair
+	for _, p := range pixels {
+		referencePoints = append(referencePoints, MapImagePair{Geographic: expectGeo(p), Pixel: p})
+	}
+
+	sut, err := NewGeoreference(ConformalModel, LatLngCRS, referencePoints, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []LatLng{{Lat: 0, Lng: 0}, {Lat: 5000, Lng: 300}, {Lat: 10000, Lng: 10000}} {
+		result := sut.GeoFromPixel(p)
+		expect := expectGeo(p)
+		if !Point(result).IsCloseTo(Point(expect)) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", p, result, expect)
+		}
+
+		back := sut.PixelFromGeo(result)
+		if !floats.EqualWithinAbs(back.Lat, p.Lat, 0.0001) || !floats.EqualWithinAbs(back.Lng, p.Lng, 0.0001) {
+			t.Errorf("round trip incorrect for %v, got: %v.", p, back)
+		}
+	}
+}
+
 func TestMineLevelProjectionsViaTheImplementation(t *testing.T) {
 
 	minePoints := []Point{
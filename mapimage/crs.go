@@ -0,0 +1,363 @@
+package mapimage
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CRS is the coordinate reference system of the geographic half of the
+// reference points, e.g. the grid printed on a map
+type CRS struct {
+	Name string
+	// From lat/lng on the datum to the eastings and northings of the CRS, or
+	// nil when the CRS is lat/lng itself
+	projection Transformation
+	// The ellipsoid the projection is on
+	ellipsoid Ellipsoid
+	// From lat/lng on the datum to WGS84 lat/lng, or nil when it is WGS84
+	datum Transformation
+}
+
+// LatLngCRS is plain WGS84 lat/lng, which is what is used when no CRS is given
+var LatLngCRS = CRS{Name: "EPSG:4326"}
+
+// ParseCRS understands EPSG codes (e.g. "EPSG:32633") for the systems in
+// epsgCRS, and PROJ strings (e.g. "+proj=utm +zone=33 +south") for the
+// longlat, merc, tmerc, utm and lcc projections. An empty name is lat/lng.
+func ParseCRS(name string) (CRS, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return LatLngCRS, nil
+
+	case strings.HasPrefix(name, "+"):
+		crs, err := parseProj(name)
+		crs.Name = name
+		return crs, err
+
+	case strings.HasPrefix(strings.ToUpper(name), "EPSG:"):
+		code, err := strconv.Atoi(name[len("EPSG:"):])
+		if err != nil {
+			return CRS{}, fmt.Errorf("crs: bad EPSG code %q", name)
+		}
+		crs, err := epsgCRS(code)
+		crs.Name = fmt.Sprintf("EPSG:%d", code)
+		return crs, err
+	}
+
+	return CRS{}, fmt.Errorf("crs: unknown coordinate reference system %q", name)
+}
+
+func newCRS(projection Transformation, datum Datum) CRS {
+	return CRS{projection: projection, ellipsoid: datum.Ellipsoid, datum: datum.toWGS84()}
+}
+
+// WithDatum is the same CRS, but on another datum (e.g. lat/lng that was
+// surveyed on ED50). A projected CRS has to be on the datum's ellipsoid.
+func (c CRS) WithDatum(d Datum) (CRS, error) {
+	if c.datum != nil {
+		return CRS{}, fmt.Errorf("crs: %v already has a datum", c.Name)
+	}
+	if err := d.validate(); err != nil {
+		return CRS{}, err
+	}
+	if c.projection != nil && c.ellipsoid != d.Ellipsoid {
+		return CRS{}, fmt.Errorf("crs: %v isn't on the ellipsoid of %v", c.Name, d.Name)
+	}
+	c.Name = fmt.Sprintf("%v (%v)", c.Name, d.Name)
+	c.datum = d.toWGS84()
+	return c, nil
+}
+
+// Projected is true when the CRS is eastings and northings, not lat/lng
+func (c CRS) Projected() bool {
+	return c.projection != nil
+}
+
+// toLatLng is the transformation from the CRS to WGS84 lat/lng, or nil if
+// it already is WGS84 lat/lng
+func (c CRS) toLatLng() Transformation {
+	var steps []Transformation
+	if c.projection != nil {
+		steps = append(steps, c.projection.Inverse())
+	}
+	if c.datum != nil {
+		steps = append(steps, c.datum)
+	}
+
+	switch len(steps) {
+	case 0:
+		return nil
+	case 1:
+		return steps[0]
+	}
+	chain := NewChainedTransformation(steps...)
+	return &chain
+}
+
+// ToLatLng converts points in the CRS to WGS84 lat/lng
+func (c CRS) ToLatLng(points ...Point) []Point {
+	if t := c.toLatLng(); t != nil {
+		return t.Projects(points...)
+	}
+	return points
+}
+
+// FromLatLng converts WGS84 lat/lng to points in the CRS
+func (c CRS) FromLatLng(points ...Point) []Point {
+	if t := c.toLatLng(); t != nil {
+		return t.Inverse().Projects(points...)
+	}
+	return points
+}
+
+// referencePointsToLatLng is the reference points with their geographic
+// halves converted to WGS84 lat/lng
+func (c CRS) referencePointsToLatLng(referencePoints []MapImagePair) []MapImagePair {
+	geo, _ := splitReferencePoints(referencePoints)
+	converted := make([]MapImagePair, len(referencePoints))
+	for i, p := range c.ToLatLng(geo...) {
+		converted[i] = MapImagePair{Geographic: LatLng(p), Pixel: referencePoints[i].Pixel}
+	}
+	return converted
+}
+
+// epsgCRS is the CRS for each of the EPSG codes we know about
+func epsgCRS(code int) (CRS, error) {
+	wgs84, _ := datumByName("WGS84")
+	etrs89, _ := datumByName("ETRS89")
+	ed50, _ := datumByName("ED50")
+	osgb36, _ := datumByName("OSGB36")
+	dhdn, _ := datumByName("DHDN")
+	nad27, _ := datumByName("NAD27")
+
+	switch {
+	case code == 4326:
+		return newCRS(nil, wgs84), nil
+	case code == 4258:
+		return newCRS(nil, etrs89), nil
+	case code == 4230:
+		return newCRS(nil, ed50), nil
+	case code == 4277:
+		return newCRS(nil, osgb36), nil
+	case code == 4314:
+		return newCRS(nil, dhdn), nil
+	case code == 4267:
+		return newCRS(nil, nad27), nil
+	case code == 3857 || code == 900913:
+		t := NewWebMercatorTransformation()
+		return newCRS(&t, wgs84), nil
+	case code == 3395:
+		t := NewMercatorTransformation(WGS84, 0, 1, 0, 0)
+		return newCRS(&t, wgs84), nil
+	case code > 32600 && code <= 32660:
+		t := NewUTMTransformation(code-32600, false)
+		return newCRS(&t, wgs84), nil
+	case code > 32700 && code <= 32760:
+		t := NewUTMTransformation(code-32700, true)
+		return newCRS(&t, wgs84), nil
+	case code >= 25828 && code <= 25838:
+		// ETRS89 / UTM
+		t := NewUTMTransformationOn(GRS80, code-25800, false)
+		return newCRS(&t, etrs89), nil
+	case code >= 23028 && code <= 23038:
+		// ED50 / UTM
+		t := NewUTMTransformationOn(International1924, code-23000, false)
+		return newCRS(&t, ed50), nil
+	case code >= 26703 && code <= 26722:
+		// NAD27 / UTM
+		t := NewUTMTransformationOn(Clarke1866, code-26700, false)
+		return newCRS(&t, nad27), nil
+	case code == 2154:
+		// RGF93 / Lambert-93
+		t := NewLambertConformalConicTransformation(GRS80, 46.5, 3, 49, 44, 700000, 6600000)
+		return newCRS(&t, etrs89), nil
+	case code == 27700:
+		// OSGB36 / British National Grid
+		t := NewTransverseMercatorTransformation(Airy1830, 49, -2, 0.9996012717, 400000, -100000)
+		return newCRS(&t, osgb36), nil
+	case code >= 31466 && code <= 31469:
+		// DHDN / Gauss-Kruger zones 2 to 5
+		zone := float64(code - 31464)
+		t := NewTransverseMercatorTransformation(Bessel1841, 0, zone*3, 1, zone*1000000+500000, 0)
+		return newCRS(&t, dhdn), nil
+	}
+	return CRS{}, fmt.Errorf("crs: unsupported EPSG code %d", code)
+}
+
+// The PROJ parameters that make no difference to us
+var projIgnored = map[string]bool{
+	"no_defs": true,
+	"type":    true,
+	"wktext":  true,
+}
+
+// parseProj turns a PROJ string into a CRS
+func parseProj(s string) (CRS, error) {
+	params := make(map[string]string)
+	for _, token := range strings.Fields(s) {
+		kv := strings.SplitN(strings.TrimPrefix(token, "+"), "=", 2)
+		if len(kv) == 1 {
+			params[kv[0]] = ""
+		} else {
+			params[kv[0]] = kv[1]
+		}
+	}
+
+	used := map[string]bool{"proj": true}
+	number := func(key string, def float64) (float64, error) {
+		used[key] = true
+		v, ok := params[key]
+		if !ok {
+			return def, nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("crs: bad +%v=%v", key, v)
+		}
+		return f, nil
+	}
+	var err error
+	numbers := func(keys []string, defs []float64) []float64 {
+		values := make([]float64, len(keys))
+		for i, key := range keys {
+			if err == nil {
+				values[i], err = number(key, defs[i])
+			}
+		}
+		return values
+	}
+
+	datum, _ := datumByName("WGS84")
+	if name, ok := params["datum"]; ok {
+		used["datum"] = true
+		if datum, err = datumByName(name); err != nil {
+			return CRS{}, err
+		}
+	}
+	if name, ok := params["ellps"]; ok {
+		used["ellps"] = true
+		if datum.Ellipsoid, err = ellipsoidByName(name); err != nil {
+			return CRS{}, err
+		}
+	}
+	if _, ok := params["a"]; ok {
+		v := numbers([]string{"a", "rf", "b"}, []float64{0, 0, 0})
+		if err != nil {
+			return CRS{}, err
+		}
+		a, rf, b := v[0], v[1], v[2]
+		_, hasRf := params["rf"]
+		_, hasB := params["b"]
+		switch {
+		case hasRf:
+		case hasB && b > 0 && b <= a:
+			// Infinite when b is a, i.e. a sphere
+			rf = a / (a - b)
+		case hasB:
+			return CRS{}, fmt.Errorf("crs: bad +b=%v", params["b"])
+		default:
+			// Just +a is a sphere of that radius
+			rf = math.Inf(1)
+		}
+		if !(a > 0) || !(rf > 1) {
+			return CRS{}, fmt.Errorf("crs: bad ellipsoid, +a=%v +rf=%v", params["a"], rf)
+		}
+		datum.Ellipsoid = Ellipsoid{A: a, InvF: rf}
+	}
+	if towgs84, ok := params["towgs84"]; ok {
+		used["towgs84"] = true
+		datum.Name = "custom"
+		datum.ToWGS84 = nil
+		for _, v := range strings.Split(towgs84, ",") {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return CRS{}, fmt.Errorf("crs: bad +towgs84=%v", towgs84)
+			}
+			datum.ToWGS84 = append(datum.ToWGS84, f)
+		}
+		if err := datum.validate(); err != nil {
+			return CRS{}, err
+		}
+	}
+	ellipsoid := datum.Ellipsoid
+
+	var projection Transformation
+	switch params["proj"] {
+	case "longlat", "latlong", "lonlat", "latlon":
+		projection = nil
+
+	case "merc":
+		v := numbers([]string{"lon_0", "k_0", "x_0", "y_0"}, []float64{0, 1, 0, 0})
+		if _, ok := params["k"]; ok {
+			v[1], err = number("k", 1)
+		}
+		t := NewMercatorTransformation(ellipsoid, v[0], v[1], v[2], v[3])
+		projection = &t
+
+	case "tmerc":
+		v := numbers([]string{"lat_0", "lon_0", "k_0", "x_0", "y_0"}, []float64{0, 0, 1, 0, 0})
+		if _, ok := params["k"]; ok {
+			v[2], err = number("k", 1)
+		}
+		t := NewTransverseMercatorTransformation(ellipsoid, v[0], v[1], v[2], v[3], v[4])
+		projection = &t
+
+	case "utm":
+		v := numbers([]string{"zone"}, []float64{0})
+		_, south := params["south"]
+		used["south"] = true
+		if err == nil && (v[0] < 1 || v[0] > 60) {
+			err = fmt.Errorf("crs: bad UTM zone %v", params["zone"])
+		}
+		t := NewUTMTransformationOn(ellipsoid, int(v[0]), south)
+		projection = &t
+
+	case "lcc":
+		v := numbers([]string{"lat_1"}, []float64{0})
+		v = append(v, numbers(
+			[]string{"lat_2", "lat_0", "lon_0", "k_0", "x_0", "y_0"},
+			[]float64{v[0], 0, 0, 1, 0, 0})...)
+		if _, ok := params["k"]; ok {
+			v[4], err = number("k", 1)
+		}
+		t := newLambertConformalConic(ellipsoid, v[2], v[3], v[0], v[1], v[4], v[5], v[6])
+		projection = &t
+
+	default:
+		return CRS{}, fmt.Errorf("crs: unsupported projection %q", params["proj"])
+	}
+	if err != nil {
+		return CRS{}, err
+	}
+
+	// The eastings and northings might not be in metres
+	if units, ok := params["units"]; ok {
+		used["units"] = true
+		var metres float64
+		switch units {
+		case "m":
+			metres = 1
+		case "ft":
+			metres = 0.3048
+		case "us-ft":
+			metres = 1200.0 / 3937.0
+		default:
+			return CRS{}, fmt.Errorf("crs: unsupported units %q", units)
+		}
+		if metres != 1 && projection != nil {
+			scale := NewAffineNoRotTransformation(1/metres, 1/metres, 0, 0)
+			chain := NewChainedTransformation(projection, &scale)
+			projection = &chain
+		}
+	}
+
+	for key := range params {
+		if !used[key] && !projIgnored[key] {
+			return CRS{}, fmt.Errorf("crs: unsupported parameter +%v", key)
+		}
+	}
+	return newCRS(projection, datum), nil
+}
@@ -0,0 +1,188 @@
+package mapimage
+
+import (
+	"encoding/json"
+	"gonum.org/v1/gonum/floats"
+	"testing"
+)
+
+func dms(degrees, minutes, seconds float64) float64 {
+	if degrees < 0 {
+		return degrees - minutes/60 - seconds/3600
+	}
+	return degrees + minutes/60 + seconds/3600
+}
+
+// The worked examples from the EPSG guidance note 7-2
+var crsTestExamples = []struct {
+	name       string
+	projection Transformation
+	geo        Point
+	projected  Point
+}{
+	{
+		"OSGB 1936 / British National Grid",
+		func() Transformation {
+			t := NewTransverseMercatorTransformation(Airy1830, 49, -2, 0.9996012717, 400000, -100000)
+			return &t
+		}(),
+		PointNorthingEasting(dms(50, 30, 0), dms(0, 30, 0)),
+		PointEastingNorthing(577274.99, 69740.50),
+	},
+	{
+		"JAD69 / Jamaica National Grid",
+		func() Transformation {
+			t := NewLambertConformalConic1SPTransformation(Clarke1866, 18, -77, 1, 250000, 150000)
+			return &t
+		}(),
+		PointNorthingEasting(dms(17, 55, 55.80), dms(-76, 56, 37.26)),
+		PointEastingNorthing(255966.58, 142493.51),
+	},
+	{
+		"Makassar / NEIEZ",
+		func() Transformation {
+			t := NewMercatorTransformation(Bessel1841, 110, 0.997, 3900000, 900000)
+			return &t
+		}(),
+		PointNorthingEasting(-3, 120),
+		PointEastingNorthing(5009726.58, 569150.82),
+	},
+	{
+		"NAD27 / Texas South Central",
+		func() Transformation {
+			c, err := ParseCRS("+proj=lcc +lat_1=28.383333333333 +lat_2=30.283333333333 +lat_0=27.833333333333 " +
+				"+lon_0=-99 +x_0=609601.2192 +y_0=0 +ellps=clrk66 +units=us-ft +no_defs")
+			if err != nil {
+				panic(err)
+			}
+			return c.projection
+		}(),
+		PointNorthingEasting(28.5, -96),
+		PointEastingNorthing(2963503.91, 254759.80),
+	},
+}
+
+func TestProjectionExamples(t *testing.T) {
+	for _, example := range crsTestExamples {
+		result := example.projection.Project(example.geo)
+		if !floats.EqualWithinAbs(result.Lat, example.projected.Lat, 0.01) ||
+			!floats.EqualWithinAbs(result.Lng, example.projected.Lng, 0.01) {
+			t.Errorf("%v: incorrect for %v, got: %v, want: %v.", example.name, example.geo, result, example.projected)
+		}
+
+		result = example.projection.Inverse().Project(example.projected)
+		if !floats.EqualWithinAbs(result.Lat, example.geo.Lat, 1e-7) ||
+			!floats.EqualWithinAbs(result.Lng, example.geo.Lng, 1e-7) {
+			t.Errorf("%v: incorrect for %v, got: %v, want: %v.", example.name, example.projected, result, example.geo)
+		}
+	}
+}
+
+func TestCRSRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		crs string
+		geo Point
+	}{
+		{"EPSG:32633", PointNorthingEasting(52.5, 13.4)},
+		{"EPSG:32735", PointNorthingEasting(-33.9, 25.6)},
+		{"EPSG:25831", PointNorthingEasting(41.4, 2.2)},
+		{"EPSG:2154", PointNorthingEasting(48.85, 2.35)},
+		{"EPSG:3857", PointNorthingEasting(40.25, 26.25)},
+		{"EPSG:3395", PointNorthingEasting(-37.5, 149.9)},
+		{"+proj=utm +zone=35 +ellps=WGS84 +units=m +no_defs", PointNorthingEasting(40.25, 26.25)},
+		{"+proj=tmerc +lat_0=0 +lon_0=173 +k=0.9996 +x_0=1600000 +y_0=10000000 +ellps=GRS80", PointNorthingEasting(-41.3, 174.8)},
+		{"+proj=tmerc +lon_0=9 +a=6371000", PointNorthingEasting(48.1, 11.6)},
+		{"+proj=tmerc +lon_0=27 +a=6378137 +b=6356752.314245", PointNorthingEasting(40.25, 26.25)},
+		{"+proj=lcc +lat_1=45 +lat_2=55 +lon_0=10 +a=6378137 +b=6378137", PointNorthingEasting(50.1, 8.7)},
+	} {
+		crs, err := ParseCRS(tc.crs)
+		if err != nil {
+			t.Errorf("%v: %v", tc.crs, err)
+			continue
+		}
+		result := crs.ToLatLng(crs.FromLatLng(tc.geo)...)[0]
+		if !result.IsCloseTo(tc.geo) {
+			t.Errorf("%v: round trip incorrect for %v, got: %v.", tc.crs, tc.geo, result)
+		}
+	}
+}
+
+func TestSphericalCRS(t *testing.T) {
+	// A sphere the size of WGS84's semi-major axis is Web Mercator's
+	for _, proj := range []string{"+proj=merc +a=6378137", "+proj=merc +a=6378137 +b=6378137"} {
+		crs, err := ParseCRS(proj)
+		if err != nil {
+			t.Fatalf("%v: %v", proj, err)
+		}
+		webMercator, _ := ParseCRS("EPSG:3857")
+		geo := PointNorthingEasting(40.25, 26.25)
+		result, expected := crs.FromLatLng(geo)[0], webMercator.FromLatLng(geo)[0]
+		if !floats.EqualWithinAbs(result.Lat, expected.Lat, 0.01) || !floats.EqualWithinAbs(result.Lng, expected.Lng, 0.01) {
+			t.Errorf("%v: incorrect for %v, got: %v, want: %v.", proj, geo, result, expected)
+		}
+	}
+}
+
+func TestUnsupportedCRS(t *testing.T) {
+	for _, name := range []string{"EPSG:1234", "EPSG:three", "+proj=robin", "+proj=utm +zone=61", "+proj=tmerc +lat_0=bob", "+proj=merc +foo=1", "OSGB",
+		"+proj=merc +a=6378137 +rf=0", "+proj=merc +a=6378137 +b=7000000", "+proj=merc +a=0", "+proj=tmerc +a=6378137 +b=x"} {
+		if _, err := ParseCRS(name); err == nil {
+			t.Errorf("expected an error for %v", name)
+		}
+	}
+}
+
+func TestUnmarshalEastingNorthing(t *testing.T) {
+	var pair MapImagePair
+	err := json.Unmarshal([]byte(`{"geo": {"easting": 500123.5, "northing": 4455000}, "pixel": {"lat": 10, "lng": 20}}`), &pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair.Geographic != (LatLng{Lat: 4455000, Lng: 500123.5}) || pair.Pixel != (LatLng{Lat: 10, Lng: 20}) {
+		t.Errorf("incorrect, got: %v.", pair)
+	}
+
+	for _, bad := range []string{`{"lat": 1, "easting": 2}`, `{"lat": 1}`, `{"lat": 1, "lng": 2, "height": 3}`} {
+		var ll LatLng
+		if err := json.Unmarshal([]byte(bad), &ll); err == nil {
+			t.Errorf("expected an error for %v", bad)
+		}
+	}
+}
+
+func TestGeoreferenceInUTM(t *testing.T) {
+	crs, err := ParseCRS("EPSG:32635")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A map with a (slightly rotated) UTM grid, 2m per pixel
+	truth := NewAffineTransformation(1.99, 0.1, 0.1, -1.99, 420000, 4460000)
+	var referencePoints []MapImagePair
+	for _, pixel := range []Point{PointXY(100, 200), PointXY(9000, 300), PointXY(400, 8000), PointXY(8500, 8800)} {
+		referencePoints = append(referencePoints, MapImagePair{
+			Geographic: LatLng(truth.Project(pixel)),
+			Pixel:      LatLng(pixel),
+		})
+	}
+
+	for _, model := range []string{AffineModel, ConformalModel, ProjectiveModel} {
+		sut, err := NewGeoreference(model, crs, referencePoints, 0)
+		if err != nil {
+			t.Errorf("%v: %v", model, err)
+			continue
+		}
+		for _, pixel := range []Point{PointXY(0, 0), PointXY(5000, 5000), PointXY(10000, 2000)} {
+			result := sut.GeoFromPixel(LatLng(pixel)).toPoint()
+			expect := crs.ToLatLng(truth.Project(pixel))[0]
+			if !floats.EqualWithinAbs(result.Lat, expect.Lat, 1e-6) ||
+				!floats.EqualWithinAbs(result.Lng, expect.Lng, 1e-6) {
+				t.Errorf("%v: incorrect for %v, got: %v, want: %v.", model, pixel, result, expect)
+			}
+		}
+		// The reference points are kept as lat/lng
+		if rp := sut.ReferencePoints[0].Geographic; rp.Lat > 90 || rp.Lng > 180 {
+			t.Errorf("%v: expected lat/lng, got: %v", model, rp)
+		}
+	}
+}
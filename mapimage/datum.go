@@ -0,0 +1,147 @@
+package mapimage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Datum is the ellipsoid (and where it sits) that old maps were surveyed
+// on, which can put them 100m or more away from the same place in WGS84
+type Datum struct {
+	Name      string
+	Ellipsoid Ellipsoid
+	// The shift to WGS84: either 3 translations (in metres), or 7 which are
+	// the translations, rotations (in arc seconds, position vector) and scale
+	// (in ppm), i.e. the same as PROJ's +towgs84
+	ToWGS84 []float64
+	// Use the Molodensky formulas, rather than Helmert (3 parameters only)
+	Molodensky bool
+}
+
+// The datums that we know the shifts for (NB: these are the "average" shifts
+// for each, and a local set of parameters might well be better)
+var datums = map[string]Datum{
+	"wgs84":   {Name: "WGS84", Ellipsoid: WGS84},
+	"etrs89":  {Name: "ETRS89", Ellipsoid: GRS80},
+	"nad83":   {Name: "NAD83", Ellipsoid: GRS80},
+	"nad27":   {Name: "NAD27", Ellipsoid: Clarke1866, ToWGS84: []float64{-8, 160, 176}},
+	"ed50":    {Name: "ED50", Ellipsoid: International1924, ToWGS84: []float64{-87, -98, -121}},
+	"osgb36":  {Name: "OSGB36", Ellipsoid: Airy1830, ToWGS84: []float64{446.448, -125.157, 542.06, 0.15, 0.247, 0.842, -20.489}},
+	"dhdn":    {Name: "DHDN", Ellipsoid: Bessel1841, ToWGS84: []float64{598.1, 73.7, 418.2, 0.202, 0.045, -2.455, 6.7}},
+	"tokyo":   {Name: "Tokyo", Ellipsoid: Bessel1841, ToWGS84: []float64{-146.414, 507.337, 680.507}},
+	"arc1960": {Name: "Arc 1960", Ellipsoid: Clarke1880, ToWGS84: []float64{-160, -6, -302}},
+}
+
+// The other names for some of them, e.g. as used by PROJ's +datum
+var datumAliases = map[string]string{
+	"potsdam": "dhdn",
+}
+
+func datumByName(name string) (Datum, error) {
+	key := strings.ToLower(strings.Replace(name, " ", "", -1))
+	if alias, ok := datumAliases[key]; ok {
+		key = alias
+	}
+	d, ok := datums[key]
+	if !ok {
+		return Datum{}, fmt.Errorf("unknown datum %q", name)
+	}
+	return d, nil
+}
+
+// DatumConfig is how a datum is chosen for an image: either by name, or by
+// giving the ellipsoid and shift (or both, to override the shift)
+type DatumConfig struct {
+	Name      string    `json:"name"`
+	Ellipsoid string    `json:"ellipsoid"`
+	ToWGS84   []float64 `json:"toWGS84"`
+	// "helmert" (the default) or "molodensky"
+	Method string `json:"method"`
+}
+
+// UnmarshalJSON also accepts just the name of the datum, e.g. "ED50"
+func (c *DatumConfig) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*c = DatumConfig{Name: name}
+		return nil
+	}
+
+	// (A new type, so this isn't called again)
+	type datumConfig DatumConfig
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	return d.Decode((*datumConfig)(c))
+}
+
+func NewDatum(config DatumConfig) (Datum, error) {
+	var d Datum
+	if config.Name != "" {
+		var err error
+		if d, err = datumByName(config.Name); err != nil {
+			return Datum{}, err
+		}
+	} else {
+		if config.Ellipsoid == "" {
+			return Datum{}, fmt.Errorf("datum: needs either a name or an ellipsoid")
+		}
+		d.Name = "custom"
+	}
+
+	if config.Ellipsoid != "" {
+		e, err := ellipsoidByName(config.Ellipsoid)
+		if err != nil {
+			return Datum{}, err
+		}
+		d.Ellipsoid = e
+	}
+	if config.ToWGS84 != nil {
+		d.ToWGS84 = config.ToWGS84
+	}
+
+	switch strings.ToLower(config.Method) {
+	case "", "helmert":
+		d.Molodensky = false
+	case "molodensky":
+		d.Molodensky = true
+	default:
+		return Datum{}, fmt.Errorf("datum: unknown method %q", config.Method)
+	}
+
+	if err := d.validate(); err != nil {
+		return Datum{}, err
+	}
+	return d, nil
+}
+
+func (d Datum) validate() error {
+	if n := len(d.ToWGS84); n != 0 && n != 3 && n != 7 {
+		return fmt.Errorf("datum: toWGS84 needs 3 or 7 parameters, not %v", n)
+	}
+	if d.Molodensky && len(d.ToWGS84) == 7 {
+		return fmt.Errorf("datum: molodensky only takes the 3 translations")
+	}
+	return nil
+}
+
+// toWGS84 is the transformation from lat/lng on the datum to WGS84, or nil
+// without a shift
+func (d Datum) toWGS84() Transformation {
+	p := append(append([]float64{}, d.ToWGS84...), make([]float64, 7-len(d.ToWGS84))...)
+	shifted := false
+	for _, v := range p {
+		shifted = shifted || v != 0
+	}
+	if !shifted {
+		return nil
+	}
+
+	if d.Molodensky {
+		t := NewMolodenskyTransformation(d.Ellipsoid, WGS84, p[0], p[1], p[2])
+		return &t
+	}
+	t := NewHelmertTransformation(d.Ellipsoid, WGS84, p[0], p[1], p[2], p[3], p[4], p[5], p[6])
+	return &t
+}
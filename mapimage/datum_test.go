@@ -0,0 +1,152 @@
+package mapimage
+
+import (
+	"encoding/json"
+	"gonum.org/v1/gonum/floats"
+	"testing"
+)
+
+func datumTestTransformation(t *testing.T, config DatumConfig) Transformation {
+	d, err := NewDatum(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.toWGS84()
+}
+
+func TestGreenwichMeridian(t *testing.T) {
+	// The Airy transit circle (which the OSGB36 longitudes are measured from)
+	// is about 100m west of the WGS84 prime meridian
+	sut := datumTestTransformation(t, DatumConfig{Name: "OSGB36"})
+	result := sut.Project(PointNorthingEasting(51.4773, 0))
+
+	if !floats.EqualWithinAbs(result.Lng, -0.0015, 0.0002) {
+		t.Errorf("incorrect, got: %v, want: lng -0.0015.", result)
+	}
+}
+
+func TestHelmertAndMolodenskyAgree(t *testing.T) {
+	helmert := datumTestTransformation(t, DatumConfig{Name: "ED50"})
+	molodensky := datumTestTransformation(t, DatumConfig{Name: "ED50", Method: "molodensky"})
+
+	for _, pt := range []Point{PointNorthingEasting(40.25, 26.25), PointNorthingEasting(55, -3), PointNorthingEasting(36, 15)} {
+		h, m := helmert.Project(pt), molodensky.Project(pt)
+		if d := distanceMetres(LatLng(h), LatLng(m)); d > 1 {
+			t.Errorf("incorrect for %v, got: %v and %v (%.2fm apart).", pt, h, m, d)
+		}
+		// And the shift is the 100m or so that was promised
+		if d := distanceMetres(LatLng(pt), LatLng(h)); d < 50 || d > 250 {
+			t.Errorf("incorrect shift for %v, got: %.1fm.", pt, d)
+		}
+	}
+}
+
+func TestDatumRoundTrip(t *testing.T) {
+	for _, config := range []DatumConfig{
+		{Name: "OSGB36"},
+		{Name: "DHDN"},
+		{Name: "Tokyo", Method: "molodensky"},
+		{Ellipsoid: "clrk80", ToWGS84: []float64{-130, 110, -13}, Method: "molodensky"},
+	} {
+		sut := datumTestTransformation(t, config)
+		for _, pt := range []Point{PointNorthingEasting(40.25, 26.25), PointNorthingEasting(-33.9, 151.2), PointNorthingEasting(70, -150)} {
+			result := sut.Inverse().Project(sut.Project(pt))
+			if !floats.EqualWithinAbs(result.Lat, pt.Lat, 1e-8) ||
+				!floats.EqualWithinAbs(result.Lng, pt.Lng, 1e-8) {
+				t.Errorf("%v: round trip incorrect for %v, got: %v.", config, pt, result)
+			}
+		}
+	}
+}
+
+func TestNoShift(t *testing.T) {
+	for _, config := range []DatumConfig{{Name: "WGS84"}, {Name: "ETRS89"}, {Ellipsoid: "intl"}} {
+		if sut := datumTestTransformation(t, config); sut != nil {
+			t.Errorf("%v: expected no shift", config)
+		}
+	}
+}
+
+func TestBadDatums(t *testing.T) {
+	for _, config := range []DatumConfig{
+		{Name: "Gallipoli"},
+		{},
+		{Ellipsoid: "potato"},
+		{Name: "ED50", Method: "guess"},
+		{Name: "ED50", ToWGS84: []float64{1, 2}},
+		{Ellipsoid: "bessel", ToWGS84: []float64{1, 2, 3, 4, 5, 6, 7}, Method: "molodensky"},
+	} {
+		if _, err := NewDatum(config); err == nil {
+			t.Errorf("expected an error for %v", config)
+		}
+	}
+}
+
+func TestUnmarshalDatumConfig(t *testing.T) {
+	var configs []DatumConfig
+	err := json.Unmarshal([]byte(`["ED50", {"ellipsoid": "clrk80", "toWGS84": [-130, 110, -13], "method": "molodensky"}]`), &configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 || configs[0].Name != "ED50" || configs[1].Ellipsoid != "clrk80" || len(configs[1].ToWGS84) != 3 {
+		t.Errorf("incorrect, got: %v.", configs)
+	}
+}
+
+func TestGeoreferenceOnOldDatum(t *testing.T) {
+	datum, err := NewDatum(DatumConfig{Name: "ED50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crs, err := LatLngCRS.WithDatum(datum)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The reference points were read off of an ED50 map
+	sut, err := NewGeoreference(AffineModel, crs, transformationTestReferencePoints(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unshifted, err := NewGeoreference(AffineModel, LatLngCRS, transformationTestReferencePoints(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pixel := range []LatLng{{Lat: 0, Lng: 0}, {Lat: 5000, Lng: 5000}} {
+		result := sut.GeoFromPixel(pixel)
+		expect := LatLng(datum.toWGS84().Project(unshifted.GeoFromPixel(pixel).toPoint()))
+		if d := distanceMetres(result, expect); d > 0.01 {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", pixel, result, expect)
+		}
+		if back := sut.PixelFromGeo(result); !back.toPoint().IsCloseTo(pixel.toPoint()) {
+			t.Errorf("round trip incorrect for %v, got: %v.", pixel, back)
+		}
+	}
+
+	if _, err := crs.WithDatum(datum); err == nil {
+		t.Errorf("expected an error for a second datum")
+	}
+}
+
+func TestWithDatumEllipsoid(t *testing.T) {
+	datum, err := NewDatum(DatumConfig{Name: "ED50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, ok := range map[string]bool{
+		"":                               true,
+		"+proj=utm +zone=35":             false,
+		"+proj=utm +zone=35 +ellps=intl": true,
+		"EPSG:32635":                     false,
+		"EPSG:3857":                      false,
+	} {
+		crs, err := ParseCRS(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := crs.WithDatum(datum); (err == nil) != ok {
+			t.Errorf("incorrect for %q, got: %v, want an error: %v.", name, err, !ok)
+		}
+	}
+}
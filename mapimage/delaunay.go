@@ -0,0 +1,109 @@
+package mapimage
+
+import (
+	"math"
+)
+
+// triangle is the indices of its three corners in a slice of points
+type triangle [3]int
+
+// delaunay triangulates the points (Bowyer-Watson), with none for collinear
+// points
+func delaunay(points []Point) []triangle {
+	n := len(points)
+	if n < 3 {
+		return nil
+	}
+
+	// Start with a "super triangle" that comfortably contains all the points
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		minX, maxX = math.Min(minX, p.Lng), math.Max(maxX, p.Lng)
+		minY, maxY = math.Min(minY, p.Lat), math.Max(maxY, p.Lat)
+	}
+	size := math.Max(math.Max(maxX-minX, maxY-minY), 1)
+	midX, midY := (minX+maxX)/2, (minY+maxY)/2
+
+	vertices := append([]Point{}, points...)
+	vertices = append(vertices,
+		PointXY(midX-20*size, midY-size),
+		PointXY(midX, midY+20*size),
+		PointXY(midX+20*size, midY-size),
+	)
+	triangles := []triangle{{n, n + 1, n + 2}}
+
+	for i := 0; i < n; i++ {
+		p := vertices[i]
+
+		// Dig out all the triangles that the new point is too close to...
+		var edges [][2]int
+		kept := triangles[:0]
+		for _, t := range triangles {
+			if inCircumcircle(vertices[t[0]], vertices[t[1]], vertices[t[2]], p) {
+				edges = append(edges, [2]int{t[0], t[1]}, [2]int{t[1], t[2]}, [2]int{t[2], t[0]})
+			} else {
+				kept = append(kept, t)
+			}
+		}
+		triangles = kept
+
+		// ...and fill the hole with triangles fanning out from the new point
+		// to each edge around the outside of the hole (i.e. not shared).
+		for j, e := range edges {
+			shared := false
+			for k, f := range edges {
+				if j != k && e[0] == f[1] && e[1] == f[0] {
+					shared = true
+					break
+				}
+			}
+			if !shared {
+				triangles = append(triangles, triangle{e[0], e[1], i})
+			}
+		}
+	}
+
+	// Lastly remove anything still attached to the super triangle (or flat)
+	result := make([]triangle, 0, len(triangles))
+	for _, t := range triangles {
+		if t[0] < n && t[1] < n && t[2] < n &&
+			orientation(points[t[0]], points[t[1]], points[t[2]]) != 0 {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// inCircumcircle is whether p is inside the circle through a, b and c
+func inCircumcircle(a, b, c, p Point) bool {
+	ax, ay := a.Lng-p.Lng, a.Lat-p.Lat
+	bx, by := b.Lng-p.Lng, b.Lat-p.Lat
+	cx, cy := c.Lng-p.Lng, c.Lat-p.Lat
+
+	det := (ax*ax+ay*ay)*(bx*cy-cx*by) -
+		(bx*bx+by*by)*(ax*cy-cx*ay) +
+		(cx*cx+cy*cy)*(ax*by-bx*ay)
+
+	// The sign of the determinant depends on which way around the triangle is
+	if orientation(a, b, c) > 0 {
+		return det > 0
+	}
+	return det < 0
+}
+
+// orientation is positive if a, b, c go anticlockwise, negative if they go
+// clockwise and zero if they are in a line
+func orientation(a, b, c Point) float64 {
+	return (b.Lng-a.Lng)*(c.Lat-a.Lat) - (b.Lat-a.Lat)*(c.Lng-a.Lng)
+}
+
+// barycentric returns the weights of a, b and c that make up p, which are
+// all between 0 and 1 when p is inside the triangle
+func barycentric(a, b, c, p Point) (wa, wb, wc float64) {
+	area := orientation(a, b, c)
+	wa = orientation(p, b, c) / area
+	wb = orientation(a, p, c) / area
+	wc = 1 - wa - wb
+	return
+}
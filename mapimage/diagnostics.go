@@ -0,0 +1,136 @@
+package mapimage
+
+import (
+	"math"
+)
+
+const earthRadius float64 = 6378137.0
+
+// GeoreferenceReport describes how well a map image is georeferenced, so
+// that alignment can be checked without eyeballing the tiles
+type GeoreferenceReport struct {
+	Id              string                   `json:"id"`
+	Transformation  string                   `json:"transformation"`
+	CRS             string                   `json:"crs"`
+	Parameters      TransformationParameters `json:"parameters"`
+	ReferencePoints []ReferencePointResidual `json:"referencePoints"`
+	RMSEMetres      float64                  `json:"rmseMetres"`
+	RMSEPixels      float64                  `json:"rmsePixels"`
+	// Metres on the ground per image pixel, at the centre of the image
+	GroundResolution float64 `json:"groundResolution"`
+}
+
+// TransformationParameters is the pixel to ground transformation at the centre
+// of the image, decomposed
+type TransformationParameters struct {
+	// Metres per pixel along each of the image axes
+	ScaleX float64 `json:"scaleX"`
+	ScaleY float64 `json:"scaleY"`
+	// Degrees anticlockwise from east to the image x axis
+	Rotation float64 `json:"rotation"`
+	// Degrees that the image y axis leans away from perpendicular
+	Shear float64 `json:"shear"`
+	// True when the image has been flipped over
+	Mirrored bool `json:"mirrored"`
+	// Where the top left corner of the image is
+	Translation LatLng `json:"translation"`
+}
+
+type ReferencePointResidual struct {
+	MapImagePair
+	ProjectedGeo   LatLng  `json:"projectedGeo"`
+	ProjectedPixel LatLng  `json:"projectedPixel"`
+	Metres         float64 `json:"metres"`
+	Pixels         float64 `json:"pixels"`
+	// Outliers were left out of the fit (and the RMSEs)
+	Outlier bool `json:"outlier"`
+}
+
+func NewGeoreferenceReport(i MapImage) GeoreferenceReport {
+	georef := i.Georeference()
+	report := GeoreferenceReport{
+		Id:              i.Id(),
+		Transformation:  georef.Transformation,
+		CRS:             georef.CRS,
+		ReferencePoints: make([]ReferencePointResidual, 0),
+	}
+
+	sumSqMetres, sumSqPixels := 0.0, 0.0
+	for idx, rp := range append(append([]MapImagePair{}, georef.ReferencePoints...), georef.Outliers...) {
+		r := ReferencePointResidual{
+			MapImagePair:   rp,
+			ProjectedGeo:   i.GeoFromPixel(rp.Pixel),
+			ProjectedPixel: i.PixelFromGeo(rp.Geographic),
+			Outlier:        idx >= len(georef.ReferencePoints),
+		}
+		r.Metres = distanceMetres(rp.Geographic, r.ProjectedGeo)
+		r.Pixels = math.Hypot(rp.Pixel.Lng-r.ProjectedPixel.Lng, rp.Pixel.Lat-r.ProjectedPixel.Lat)
+
+		if !r.Outlier {
+			sumSqMetres += r.Metres * r.Metres
+			sumSqPixels += r.Pixels * r.Pixels
+		}
+		report.ReferencePoints = append(report.ReferencePoints, r)
+	}
+	if n := float64(len(georef.ReferencePoints)); n > 0 {
+		report.RMSEMetres = math.Sqrt(sumSqMetres / n)
+		report.RMSEPixels = math.Sqrt(sumSqPixels / n)
+	}
+
+	pixelBounds := i.PixelBounds()
+	centre := LatLng{
+		Lat: (pixelBounds[0].Lat + pixelBounds[1].Lat) / 2,
+		Lng: (pixelBounds[0].Lng + pixelBounds[1].Lng) / 2,
+	}
+	report.Parameters = decomposeAt(i, centre)
+	report.Parameters.Translation = i.GeoFromPixel(pixelBounds[0])
+	report.GroundResolution = math.Sqrt(math.Abs(report.Parameters.ScaleX * report.Parameters.ScaleY))
+
+	return report
+}
+
+// decomposeAt splits the jacobian (in local metres) at p into rotation * scale
+// * shear
+func decomposeAt(i MapImage, p LatLng) TransformationParameters {
+	origin := i.GeoFromPixel(p)
+	alongX := i.GeoFromPixel(LatLng{Lat: p.Lat, Lng: p.Lng + 1})
+	// NB: pixel y runs down the image, whereas north is up
+	alongY := i.GeoFromPixel(LatLng{Lat: p.Lat - 1, Lng: p.Lng})
+
+	a, c := localMetres(origin, alongX)
+	b, d := localMetres(origin, alongY)
+
+	//   --    --     --                --     --     --     --    --
+	//  | a  b  |    | cos(θ)  -sin(θ)  |   | Sx  0  |   | 1  k  |
+	//  |       | =  |                  | * |        | * |       |
+	//  | c  d  |    | sin(θ)   cos(θ)  |   | 0   Sy |   | 0  1  |
+	//   --    --     --                --     --     --     --    --
+	theta := math.Atan2(c, a)
+	sx := math.Hypot(a, c)
+	sy := -math.Sin(theta)*b + math.Cos(theta)*d
+	k := (math.Cos(theta)*b + math.Sin(theta)*d) / sx
+
+	return TransformationParameters{
+		ScaleX:   sx,
+		ScaleY:   math.Abs(sy),
+		Rotation: DegFromRad(theta),
+		Shear:    DegFromRad(math.Atan(k)),
+		Mirrored: sy < 0,
+	}
+}
+
+// localMetres is how far east and north b is from a
+func localMetres(a, b LatLng) (east, north float64) {
+	east = RadFromDeg(b.Lng-a.Lng) * math.Cos(RadFromDeg(a.Lat)) * earthRadius
+	north = RadFromDeg(b.Lat-a.Lat) * earthRadius
+	return
+}
+
+// distanceMetres is the great circle (haversine) distance between a and b
+func distanceMetres(a, b LatLng) float64 {
+	dLat := RadFromDeg(b.Lat - a.Lat)
+	dLng := RadFromDeg(b.Lng - a.Lng)
+	h := math.Pow(math.Sin(dLat/2), 2) +
+		math.Cos(RadFromDeg(a.Lat))*math.Cos(RadFromDeg(b.Lat))*math.Pow(math.Sin(dLng/2), 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
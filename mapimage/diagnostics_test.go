@@ -0,0 +1,179 @@
+package mapimage
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// affineTestImage is a 400x300 image whose pixels map on to the ground (in
+// metres east and north of origin, on the equator) through m, with y up
+type affineTestImage struct {
+	origin LatLng
+	m      [4]float64
+	georef Georeference
+}
+
+func (i affineTestImage) GeoFromPixel(p LatLng) LatLng {
+	east := i.m[0]*p.Lng - i.m[1]*p.Lat
+	north := i.m[2]*p.Lng - i.m[3]*p.Lat
+	return LatLng{
+		Lat: i.origin.Lat + DegFromRad(north/earthRadius),
+		Lng: i.origin.Lng + DegFromRad(east/(earthRadius*math.Cos(RadFromDeg(i.origin.Lat)))),
+	}
+}
+
+func (i affineTestImage) PixelFromGeo(p LatLng) LatLng {
+	east := RadFromDeg(p.Lng-i.origin.Lng) * earthRadius * math.Cos(RadFromDeg(i.origin.Lat))
+	north := RadFromDeg(p.Lat-i.origin.Lat) * earthRadius
+	det := i.m[0]*i.m[3] - i.m[1]*i.m[2]
+	return LatLng{
+		Lng: (i.m[3]*east - i.m[1]*north) / det,
+		Lat: -(-i.m[2]*east + i.m[0]*north) / det,
+	}
+}
+
+func (i affineTestImage) ImageContent() io.ReadSeeker                               { return nil }
+func (i affineTestImage) MapTile(zoom, x, y int64, format TileFormat) io.ReadSeeker { return nil }
+func (i affineTestImage) Id() string                                                { return "affine" }
+func (i affineTestImage) Text() string                                              { return "Affine" }
+func (i affineTestImage) GeoBounds() [2]LatLng                                      { return [2]LatLng{} }
+func (i affineTestImage) PixelBounds() [2]LatLng                                    { return [2]LatLng{{}, {Lat: 300, Lng: 400}} }
+func (i affineTestImage) MinZoom() int                                              { return 0 }
+func (i affineTestImage) MaxZoom() int                                              { return 0 }
+func (i affineTestImage) Georeference() Georeference                                { return i.georef }
+func (i affineTestImage) TileOptions() TileOptions                                  { return TileOptions{} }
+
+// newAffineTestImage maps the pixels through rotation * scale * shear
+func newAffineTestImage(rotation, scaleX, scaleY, shear float64) affineTestImage {
+	theta, k := RadFromDeg(rotation), math.Tan(RadFromDeg(shear))
+	cos, sin := math.Cos(theta), math.Sin(theta)
+	return affineTestImage{
+		origin: LatLng{Lat: 0, Lng: 7},
+		m: [4]float64{
+			cos * scaleX, cos*scaleX*k - sin*scaleY,
+			sin * scaleX, sin*scaleX*k + cos*scaleY,
+		},
+		georef: Georeference{Transformation: AffineModel},
+	}
+}
+
+func TestDecomposeAt(t *testing.T) {
+	for _, c := range []struct {
+		rotation, scaleX, scaleY, shear float64
+		mirrored                        bool
+	}{
+		{0, 1, 1, 0, false},
+		{30, 2, 3, 0, false},
+		{-75, 0.5, 4, 10, false},
+		{120, 2.5, 2.5, -20, false},
+		{15, 2, -3, 5, true},
+	} {
+		sut := decomposeAt(newAffineTestImage(c.rotation, c.scaleX, c.scaleY, c.shear), LatLng{Lat: 150, Lng: 200})
+		expected := TransformationParameters{
+			ScaleX:   c.scaleX,
+			ScaleY:   math.Abs(c.scaleY),
+			Rotation: c.rotation,
+			Shear:    c.shear,
+			Mirrored: c.mirrored,
+		}
+		if math.Abs(sut.ScaleX-expected.ScaleX) > 1e-4 || math.Abs(sut.ScaleY-expected.ScaleY) > 1e-4 ||
+			math.Abs(sut.Rotation-expected.Rotation) > 1e-4 || math.Abs(sut.Shear-expected.Shear) > 1e-4 ||
+			sut.Mirrored != expected.Mirrored {
+			t.Errorf("incorrect for %v, got: %+v, want: %+v.", c, sut, expected)
+		}
+	}
+}
+
+func TestGeoreferenceReport(t *testing.T) {
+	mi := newAffineTestImage(0, 2, 2, 0)
+	exact := MapImagePair{Pixel: LatLng{Lat: 100, Lng: 100}}
+	exact.Geographic = mi.GeoFromPixel(exact.Pixel)
+	// 10m (so 5 pixels) north of where the pixel is
+	off := MapImagePair{Pixel: LatLng{Lat: 200, Lng: 300}}
+	off.Geographic = mi.GeoFromPixel(LatLng{Lat: 195, Lng: 300})
+	// 100m out, and left out of the fit
+	outlier := MapImagePair{Pixel: LatLng{Lat: 50, Lng: 50}}
+	outlier.Geographic = mi.GeoFromPixel(LatLng{Lat: 50, Lng: 100})
+	mi.georef.ReferencePoints = []MapImagePair{exact, off}
+	mi.georef.Outliers = []MapImagePair{outlier}
+
+	sut := NewGeoreferenceReport(mi)
+	if sut.Id != "affine" || sut.Transformation != AffineModel || len(sut.ReferencePoints) != 3 {
+		t.Fatalf("incorrect, got: %+v.", sut)
+	}
+	for i, c := range []struct {
+		metres, pixels float64
+		outlier        bool
+	}{
+		{0, 0, false},
+		{10, 5, false},
+		{100, 50, true},
+	} {
+		r := sut.ReferencePoints[i]
+		if math.Abs(r.Metres-c.metres) > 1e-3 || math.Abs(r.Pixels-c.pixels) > 1e-3 || r.Outlier != c.outlier {
+			t.Errorf("incorrect for point %v, got: %vm %vpx %v, want: %vm %vpx %v.", i, r.Metres, r.Pixels, r.Outlier, c.metres, c.pixels, c.outlier)
+		}
+	}
+	// The outlier isn't in the RMSEs
+	if math.Abs(sut.RMSEMetres-math.Sqrt(50)) > 1e-3 || math.Abs(sut.RMSEPixels-math.Sqrt(12.5)) > 1e-3 {
+		t.Errorf("incorrect RMSE, got: %vm %vpx.", sut.RMSEMetres, sut.RMSEPixels)
+	}
+	if math.Abs(sut.GroundResolution-2) > 1e-4 {
+		t.Errorf("incorrect ground resolution, got: %v, want: 2.", sut.GroundResolution)
+	}
+	if tr := sut.Parameters.Translation; math.Abs(tr.Lat-mi.origin.Lat) > 1e-9 || math.Abs(tr.Lng-mi.origin.Lng) > 1e-9 {
+		t.Errorf("incorrect translation, got: %v, want: %v.", sut.Parameters.Translation, mi.origin)
+	}
+
+	// Sheared and rotated, the resolution is the square root of the area
+	// of a pixel
+	sut = NewGeoreferenceReport(newAffineTestImage(40, 2, 8, 25))
+	if math.Abs(sut.GroundResolution-4) > 1e-4 {
+		t.Errorf("incorrect ground resolution, got: %v, want: 4.", sut.GroundResolution)
+	}
+}
+
+type testImagesSource []MapImage
+
+func (s testImagesSource) ListAll() []MapImage {
+	return s
+}
+
+func (s testImagesSource) GetById(id string) (MapImage, error) {
+	for _, i := range s {
+		if i.Id() == id {
+			return i, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func TestGeoreferenceApi(t *testing.T) {
+	router := mux.NewRouter()
+	AttachApi(testImagesSource{newAffineTestImage(30, 2, 3, 0)}, router, "/imageinfo", "/file")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/imageinfo/affine/georef", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("incorrect status, got: %v.", w.Code)
+	}
+	var sut GeoreferenceReport
+	if err := json.Unmarshal(w.Body.Bytes(), &sut); err != nil {
+		t.Fatal(err)
+	}
+	if sut.Id != "affine" || math.Abs(sut.Parameters.Rotation-30) > 1e-4 || math.Abs(sut.Parameters.ScaleY-3) > 1e-4 {
+		t.Errorf("incorrect, got: %+v.", sut)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/imageinfo/missing/georef", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("incorrect status for a missing image, got: %v.", w.Code)
+	}
+}
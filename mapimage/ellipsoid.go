@@ -0,0 +1,79 @@
+package mapimage
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Ellipsoid is a semi-major axis (in metres) and inverse flattening
+type Ellipsoid struct {
+	A    float64
+	InvF float64
+}
+
+var (
+	WGS84             = Ellipsoid{A: 6378137, InvF: 298.257223563}
+	GRS80             = Ellipsoid{A: 6378137, InvF: 298.257222101}
+	Airy1830          = Ellipsoid{A: 6377563.396, InvF: 299.3249646}
+	International1924 = Ellipsoid{A: 6378388, InvF: 297}
+	Clarke1866        = Ellipsoid{A: 6378206.4, InvF: 294.9786982}
+	Bessel1841        = Ellipsoid{A: 6377397.155, InvF: 299.1528128}
+	Clarke1880        = Ellipsoid{A: 6378249.145, InvF: 293.4663}
+)
+
+// ellipsoids are the names of each, as used by PROJ's +ellps
+var ellipsoids = map[string]Ellipsoid{
+	"wgs84":  WGS84,
+	"grs80":  GRS80,
+	"airy":   Airy1830,
+	"intl":   International1924,
+	"clrk66": Clarke1866,
+	"bessel": Bessel1841,
+	"clrk80": Clarke1880,
+}
+
+func ellipsoidByName(name string) (Ellipsoid, error) {
+	e, ok := ellipsoids[strings.ToLower(name)]
+	if !ok {
+		return Ellipsoid{}, fmt.Errorf("unknown ellipsoid %q", name)
+	}
+	return e, nil
+}
+
+// F is the flattening, i.e. (a - b) / a
+func (e Ellipsoid) F() float64 {
+	return 1 / e.InvF
+}
+
+// E is the (first) eccentricity
+func (e Ellipsoid) E() float64 {
+	return math.Sqrt(e.E2())
+}
+
+// E2 is the eccentricity squared
+func (e Ellipsoid) E2() float64 {
+	f := e.F()
+	return f * (2 - f)
+}
+
+// isometricT is the t used by the (ellipsoidal) Mercator and Lambert Conformal
+// Conic projections, for the latitude phi (in radians)
+func isometricT(phi, e float64) float64 {
+	es := e * math.Sin(phi)
+	return math.Tan(math.Pi/4-phi/2) / math.Pow((1-es)/(1+es), e/2)
+}
+
+// phiFromT is the inverse of isometricT, which has to be found iteratively
+func phiFromT(t, e float64) float64 {
+	phi := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15; i++ {
+		es := e * math.Sin(phi)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-es)/(1+es), e/2))
+		if math.Abs(next-phi) < 1e-14 {
+			return next
+		}
+		phi = next
+	}
+	return phi
+}
@@ -3,15 +3,11 @@ package mapimage
 import (
 	"bytes"
 	"fmt"
-	"golang.org/x/image/draw"
 	"image"
-	"image/color"
 	_ "image/jpeg"
-	"image/png"
 	"io"
 	"io/ioutil"
 	"log"
-	"math"
 	"os"
 )
 
@@ -44,6 +40,14 @@ func (i cached) MaxZoom() int {
 	return i.mi.MaxZoom()
 }
 
+func (i cached) Georeference() Georeference {
+	return i.mi.Georeference()
+}
+
+func (i cached) TileOptions() TileOptions {
+	return i.mi.TileOptions()
+}
+
 func (i cached) GeoFromPixel(p LatLng) LatLng {
 	return i.mi.GeoFromPixel(p)
 }
@@ -56,18 +60,30 @@ func (i cached) ImageContent() io.ReadSeeker {
 	return i.mi.ImageContent()
 }
 
-func (i cached) MapTile(zoom, x, y int64) io.ReadSeeker {
-	tileMin, tileMax := TileLatLonBounds(x, y, zoom)
-	pxlMin := i.mi.PixelFromGeo(LatLng(tileMin))
-	pxlMax := i.mi.PixelFromGeo(LatLng(tileMax))
+func (i cached) pixelLevels() []image.Image {
+	if p, ok := i.mi.(pixelLevels); ok {
+		return p.pixelLevels()
+	}
+	return nil
+}
 
-	tileSize := image.Rect(0, 0, 256, 256)
-	tileRect := image.Rect(
-		int(math.Round(pxlMax.Lng)),
-		int(math.Round(pxlMax.Lat)),
-		int(math.Round(pxlMin.Lng)),
-		int(math.Round(pxlMin.Lat)),
-	)
+// tileCachePath is ./media/{id}/{kernel}/{nodata}/{z}/{x}/{y}.{ext}, or
+// {y}.q{quality}.{ext} for JPEG and WebP
+func tileCachePath(id string, options TileOptions, zoom, x, y int64, format TileFormat) (path, filename string) {
+	path = fmt.Sprintf("./media/%s/%s/%s/%d/%d", id, options.Resampling.Kernel(zoom), options.noDataHex(), zoom, x)
+	if format.lossy() {
+		return path, fmt.Sprintf("%s/%d.q%d.%s", path, y, options.quality(), format.Extension())
+	}
+	return path, fmt.Sprintf("%s/%d.%s", path, y, format.Extension())
+}
+
+// MapTile keeps the tiles where tileCachePath says
+func (i cached) MapTile(zoom, x, y int64, format TileFormat) io.ReadSeeker {
+	options := i.mi.TileOptions()
+	if format == "" {
+		format = options.format()
+	}
+	tileRect := tileFootprint(zoom, x, y, i.mi.PixelFromGeo)
 
 	pixelBounds := i.mi.PixelBounds()
 	imgBounds := image.Rect(
@@ -75,24 +91,24 @@ func (i cached) MapTile(zoom, x, y int64) io.ReadSeeker {
 		int(pixelBounds[1].Lng), int(pixelBounds[1].Lat),
 	)
 	// If the requested area is not inside the map image,
-	// then just return a black square from ram
+	// then just return an empty (nodata) tile from ram
 	if !imgBounds.Overlaps(tileRect) {
-		img := image.NewRGBA(tileSize)
-		draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.ZP, draw.Src)
-		w := bytes.Buffer{}
-		png.Encode(&w, img)
-		return bytes.NewReader(w.Bytes())
+		tile, err := options.encodeTile(options.emptyTile(), format)
+		if err != nil {
+			log.Println("encode tile", err)
+			return colorTile(zoom, x, y)
+		}
+		return tile
 	}
 
 	// Check if file already exists
-	path := fmt.Sprintf("./media/%s/%d/%d", i.mi.Id(), zoom, x)
-	filename := fmt.Sprintf("%s/%d", path, y)
+	path, filename := tileCachePath(i.mi.Id(), options, zoom, x, y, format)
 	if buf, err := ioutil.ReadFile(filename); err == nil {
 		return bytes.NewReader(buf)
 	}
 
 	// Produce the image with the underlying MapImage implementation
-	img := i.mi.MapTile(zoom, x, y)
+	img := i.mi.MapTile(zoom, x, y, format)
 
 	err := os.MkdirAll(path, 0777)
 	if err != nil {
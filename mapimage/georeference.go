@@ -0,0 +1,99 @@
+package mapimage
+
+import (
+	"fmt"
+	"math"
+)
+
+// maxRoundTripPixels is how far a reference point can move when projected to
+// the world and back again before the inverse is considered broken
+const maxRoundTripPixels = 0.01
+
+// Georeference ties the pixels of a map image to the world, via a
+// transformation fitted to a set of reference points
+type Georeference struct {
+	Transformation string
+	// The coordinate reference system that the reference points were given
+	// in (they have since been converted to lat/lng)
+	CRS string
+	// The reference points that the transformation was fitted to...
+	ReferencePoints []MapImagePair
+	// ...and any that were left out for not agreeing with the others
+	Outliers []MapImagePair
+
+	crs     CRS
+	toGeo   Transformation
+	toPixel Transformation
+}
+
+// NewGeoreference fits the transformation to the reference points (in crs),
+// leaving out any over outlierThreshold pixels off, when it is above 0
+func NewGeoreference(transformation string, crs CRS, referencePoints []MapImagePair, outlierThreshold float64) (Georeference, error) {
+	if transformation == "" {
+		transformation = AffineNoRotModel
+	}
+	var outliers []MapImagePair
+	if outlierThreshold > 0 {
+		var err error
+		referencePoints, outliers, err = rejectOutliers(transformation, crs, referencePoints, outlierThreshold)
+		if err != nil {
+			return Georeference{}, err
+		}
+	}
+	toGeo, err := NewTransformationFromReferencePoints(transformation, crs, referencePoints)
+	if err != nil {
+		return Georeference{}, err
+	}
+	toPixel := toGeo.Inverse()
+
+	// Make sure that the inverse really does go back to where it started, as
+	// the iterative ones can fail to converge when the fit is too wild
+	for _, rp := range referencePoints {
+		p := rp.Pixel.toPoint()
+		back := toPixel.Project(toGeo.Project(p))
+		if d := math.Hypot(back.Lng-p.Lng, back.Lat-p.Lat); !(d < maxRoundTripPixels) {
+			return Georeference{}, fmt.Errorf("%v transformation cannot be inverted at pixel %v (out by %.3g pixels)", transformation, p, d)
+		}
+	}
+
+	return Georeference{
+		Transformation:  transformation,
+		CRS:             crs.Name,
+		ReferencePoints: crs.referencePointsToLatLng(referencePoints),
+		Outliers:        crs.referencePointsToLatLng(outliers),
+		crs:             crs,
+		toGeo:           toGeo,
+		toPixel:         toPixel,
+	}, nil
+}
+
+// NewGeoreferenceFromTransformation is for a ready made transformation from
+// pixels to crs, e.g. a world file
+func NewGeoreferenceFromTransformation(transformation string, crs CRS, toCRS Transformation) Georeference {
+	toGeo := toCRS
+	if toLatLng := crs.toLatLng(); toLatLng != nil {
+		chain := NewChainedTransformation(toCRS, toLatLng)
+		toGeo = &chain
+	}
+	return Georeference{
+		Transformation: transformation,
+		CRS:            crs.Name,
+		crs:            crs,
+		toGeo:          toGeo,
+		toPixel:        toGeo.Inverse(),
+	}
+}
+
+func (g Georeference) GeoFromPixel(p LatLng) LatLng {
+	return LatLng(g.toGeo.Project(p.toPoint()))
+}
+
+func (g Georeference) PixelFromGeo(p LatLng) LatLng {
+	return LatLng(g.toPixel.Project(p.toPoint()))
+}
+
+// PixelResiduals reports how far (in pixels) the georeference misses each of
+// its reference points
+func (g Georeference) PixelResiduals() Residuals {
+	return PixelResiduals(g.toPixel, g.ReferencePoints)
+}
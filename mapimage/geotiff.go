@@ -0,0 +1,263 @@
+package mapimage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	// Registered for image.Decode, so that both backends can read GeoTIFFs
+	// (strip or tiled)
+	_ "golang.org/x/image/tiff"
+	"math"
+)
+
+// The TIFF tags that a GeoTIFF keeps its georeference in
+const (
+	tiffImageWidth          = 256
+	tiffImageLength         = 257
+	tiffModelPixelScale     = 33550
+	tiffModelTiepoint       = 33922
+	tiffModelTransformation = 34264
+	tiffGeoKeyDirectory     = 34735
+)
+
+// The GeoKeys (within the GeoKeyDirectory) that we use
+const (
+	geoKeyModelType      = 1024
+	geoKeyRasterType     = 1025
+	geoKeyGeographicType = 2048
+	geoKeyProjectedType  = 3072
+
+	geoModelProjected   = 1
+	geoModelGeographic  = 2
+	geoRasterPixelPoint = 2
+	geoUserDefined      = 32767
+)
+
+// GeoTIFF is the georeference embedded in a GeoTIFF
+type GeoTIFF struct {
+	Width, Height int
+	// ModelPixelScale is the size of a pixel in the CRS (x, y, z)
+	PixelScale []float64
+	// ModelTiepoint is (i, j, k, x, y, z) for each point tied to the CRS,
+	// which are GCPs when there is more than one
+	Tiepoints []float64
+	// ModelTransformation is the 4x4 matrix from pixels to the CRS (by row)
+	Transformation []float64
+	// The GeoKeys that have a value in the directory itself (i.e. that are
+	// SHORTs, which is all of the ones we need)
+	GeoKeys map[uint16]uint16
+}
+
+// ReadGeoTIFF reads the georeferencing tags from the first image of a
+// (classic, not BigTIFF) TIFF file
+func ReadGeoTIFF(r io.ReadSeeker) (GeoTIFF, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return GeoTIFF{}, err
+	}
+
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return GeoTIFF{}, errors.New("geotiff: not a TIFF file")
+	}
+	switch order.Uint16(header[2:]) {
+	case 42:
+	case 43:
+		return GeoTIFF{}, errors.New("geotiff: BigTIFF is not supported")
+	default:
+		return GeoTIFF{}, errors.New("geotiff: not a TIFF file")
+	}
+
+	entries, err := readTIFFEntries(r, order, int64(order.Uint32(header[4:])))
+	if err != nil {
+		return GeoTIFF{}, err
+	}
+
+	g := GeoTIFF{GeoKeys: make(map[uint16]uint16)}
+	for _, e := range entries {
+		switch e.tag {
+		case tiffImageWidth, tiffImageLength, tiffModelPixelScale, tiffModelTiepoint, tiffModelTransformation, tiffGeoKeyDirectory:
+		default:
+			continue
+		}
+		values, err := e.values(r, order)
+		if err != nil {
+			return GeoTIFF{}, err
+		}
+
+		switch e.tag {
+		case tiffImageWidth:
+			g.Width = int(values[0])
+		case tiffImageLength:
+			g.Height = int(values[0])
+		case tiffModelPixelScale:
+			g.PixelScale = values
+		case tiffModelTiepoint:
+			g.Tiepoints = values
+		case tiffModelTransformation:
+			g.Transformation = values
+		case tiffGeoKeyDirectory:
+			//  Header: version, revision, minor revision, number of keys
+			//  Each key: id, tag location (0 = the value is here), count, value
+			for i := 4; i+3 < len(values); i += 4 {
+				if values[i+1] == 0 {
+					g.GeoKeys[uint16(values[i])] = uint16(values[i+3])
+				}
+			}
+		}
+	}
+
+	if g.Transformation == nil && len(g.Tiepoints) < 6 {
+		return GeoTIFF{}, errors.New("geotiff: no georeference (ModelTiepoint or ModelTransformation)")
+	}
+	if len(g.Tiepoints)%6 != 0 || (g.Transformation != nil && len(g.Transformation) != 16) {
+		return GeoTIFF{}, errors.New("geotiff: malformed georeference")
+	}
+	if g.Transformation == nil && len(g.Tiepoints) == 6 && len(g.PixelScale) < 2 {
+		return GeoTIFF{}, errors.New("geotiff: a single tie point needs a ModelPixelScale")
+	}
+	return g, nil
+}
+
+// CRS is the coordinate reference system given by the GeoKeys, which must be
+// an EPSG code (user defined ones need the crs to be given in the config)
+func (g GeoTIFF) CRS() (CRS, error) {
+	var code uint16
+	switch g.GeoKeys[geoKeyModelType] {
+	case geoModelProjected:
+		code = g.GeoKeys[geoKeyProjectedType]
+	case geoModelGeographic:
+		code = g.GeoKeys[geoKeyGeographicType]
+	default:
+		return CRS{}, errors.New("geotiff: no projected or geographic model type")
+	}
+	if code == 0 || code == geoUserDefined {
+		return CRS{}, errors.New("geotiff: the CRS is not an EPSG code")
+	}
+	return ParseCRS(fmt.Sprintf("EPSG:%d", code))
+}
+
+// Georeference is the GeoTIFF's georeference in crs. GCPs (more than one tie
+// point) are fitted with the transformation, like any other reference points.
+func (g GeoTIFF) Georeference(transformation string, crs CRS, outlierThreshold float64) (Georeference, error) {
+	// With PixelIsPoint the raster coordinates are of the centres of the
+	// pixels, rather than their corners
+	offset := 0.0
+	if g.GeoKeys[geoKeyRasterType] == geoRasterPixelPoint {
+		offset = 0.5
+	}
+
+	if g.Transformation == nil && len(g.Tiepoints) > 6 {
+		var referencePoints []MapImagePair
+		for i := 0; i < len(g.Tiepoints); i += 6 {
+			tp := g.Tiepoints[i : i+6]
+			referencePoints = append(referencePoints, MapImagePair{
+				Geographic: LatLng{Lat: tp[4], Lng: tp[3]},
+				Pixel:      LatLng{Lat: tp[1] + offset, Lng: tp[0] + offset},
+			})
+		}
+		return NewGeoreference(transformation, crs, referencePoints, outlierThreshold)
+	}
+
+	//   x = a*i + b*j + Tx
+	//   y = c*i + d*j + Ty
+	var a, b, c, d, Tx, Ty float64
+	if m := g.Transformation; m != nil {
+		a, b, Tx = m[0], m[1], m[3]
+		c, d, Ty = m[4], m[5], m[7]
+	} else {
+		tp := g.Tiepoints
+		a, d = g.PixelScale[0], -g.PixelScale[1]
+		Tx, Ty = tp[3]-a*tp[0], tp[4]-d*tp[1]
+	}
+	if a*d-b*c == 0 || math.IsNaN(a*d-b*c) {
+		return Georeference{}, errors.New("geotiff: the pixel size is zero")
+	}
+
+	toCRS := NewAffineTransformation(a, b, c, d, Tx-(a+b)*offset, Ty-(c+d)*offset)
+	return NewGeoreferenceFromTransformation(AffineModel, crs, &toCRS), nil
+}
+
+type tiffEntry struct {
+	tag, datatype uint16
+	count         uint32
+	// The value itself when it fits in 4 bytes, otherwise its offset
+	value [4]byte
+}
+
+var tiffTypeSizes = map[uint16]int{
+	3:  2, // SHORT
+	4:  4, // LONG
+	11: 4, // FLOAT
+	12: 8, // DOUBLE
+}
+
+func readTIFFEntries(r io.ReadSeeker, order binary.ByteOrder, offset int64) ([]tiffEntry, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var n uint16
+	if err := binary.Read(r, order, &n); err != nil {
+		return nil, err
+	}
+
+	entries := make([]tiffEntry, n)
+	for i := range entries {
+		var raw [12]byte
+		if _, err := io.ReadFull(r, raw[:]); err != nil {
+			return nil, err
+		}
+		entries[i] = tiffEntry{
+			tag:      order.Uint16(raw[0:]),
+			datatype: order.Uint16(raw[2:]),
+			count:    order.Uint32(raw[4:]),
+		}
+		copy(entries[i].value[:], raw[8:])
+	}
+	return entries, nil
+}
+
+// values reads the (numeric) values of the entry
+func (e tiffEntry) values(r io.ReadSeeker, order binary.ByteOrder) ([]float64, error) {
+	size, ok := tiffTypeSizes[e.datatype]
+	if !ok {
+		return nil, fmt.Errorf("geotiff: tag %v has unsupported type %v", e.tag, e.datatype)
+	}
+	if e.count == 0 || e.count > 1<<20 {
+		return nil, fmt.Errorf("geotiff: tag %v has %v values", e.tag, e.count)
+	}
+
+	buf := make([]byte, size*int(e.count))
+	if len(buf) <= 4 {
+		copy(buf, e.value[:])
+	} else {
+		if _, err := r.Seek(int64(order.Uint32(e.value[:])), io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([]float64, e.count)
+	for i := range values {
+		b := buf[i*size:]
+		switch e.datatype {
+		case 3:
+			values[i] = float64(order.Uint16(b))
+		case 4:
+			values[i] = float64(order.Uint32(b))
+		case 11:
+			values[i] = float64(math.Float32frombits(order.Uint32(b)))
+		case 12:
+			values[i] = math.Float64frombits(order.Uint64(b))
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,219 @@
+package mapimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"golang.org/x/image/tiff"
+	"gonum.org/v1/gonum/floats"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"testing"
+)
+
+// geoTIFFTestFile is a TIFF of width x height pixels with the doubles (and
+// GeoKeys) added as tags, in a new IFD at the end of the file
+func geoTIFFTestFile(t *testing.T, width, height int, doubles map[uint16][]float64, geoKeys [][4]uint16) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, x%height, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	order := binary.LittleEndian
+
+	// The encoder's own entries...
+	ifd := order.Uint32(file[4:])
+	var entries [][12]byte
+	for i := 0; i < int(order.Uint16(file[ifd:])); i++ {
+		var e [12]byte
+		copy(e[:], file[int(ifd)+2+12*i:])
+		entries = append(entries, e)
+	}
+
+	// ...plus the geo ones, with their values after the end of the image
+	add := func(tag, datatype uint16, values []byte, count int) {
+		for len(file)%2 != 0 {
+			file = append(file, 0)
+		}
+		var e [12]byte
+		order.PutUint16(e[0:], tag)
+		order.PutUint16(e[2:], datatype)
+		order.PutUint32(e[4:], uint32(count))
+		order.PutUint32(e[8:], uint32(len(file)))
+		file = append(file, values...)
+		entries = append(entries, e)
+	}
+	for tag, values := range doubles {
+		b := make([]byte, 8*len(values))
+		for i, v := range values {
+			order.PutUint64(b[8*i:], math.Float64bits(v))
+		}
+		add(tag, 12, b, len(values))
+	}
+	if geoKeys != nil {
+		directory := []uint16{1, 1, 0, uint16(len(geoKeys))}
+		for _, k := range geoKeys {
+			directory = append(directory, k[:]...)
+		}
+		b := make([]byte, 2*len(directory))
+		for i, v := range directory {
+			order.PutUint16(b[2*i:], v)
+		}
+		add(tiffGeoKeyDirectory, 3, b, len(directory))
+	}
+	sort.Slice(entries, func(i, j int) bool { return order.Uint16(entries[i][:]) < order.Uint16(entries[j][:]) })
+
+	for len(file)%2 != 0 {
+		file = append(file, 0)
+	}
+	order.PutUint32(file[4:], uint32(len(file)))
+	file = append(file, 0, 0)
+	order.PutUint16(file[len(file)-2:], uint16(len(entries)))
+	for _, e := range entries {
+		file = append(file, e[:]...)
+	}
+	return append(file, 0, 0, 0, 0)
+}
+
+func TestGeoTIFFPixelScale(t *testing.T) {
+	// A 2m UTM image, tied at its top left corner
+	file := geoTIFFTestFile(t, 40, 30,
+		map[uint16][]float64{
+			tiffModelPixelScale: {2, 2, 0},
+			tiffModelTiepoint:   {0, 0, 0, 453120, 4455800, 0},
+		},
+		[][4]uint16{{geoKeyModelType, 0, 1, geoModelProjected}, {geoKeyProjectedType, 0, 1, 32635}})
+
+	sut, err := ReadGeoTIFF(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sut.Width != 40 || sut.Height != 30 {
+		t.Errorf("incorrect size, got: %vx%v, want: 40x30.", sut.Width, sut.Height)
+	}
+	crs, err := sut.CRS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crs.Name != "EPSG:32635" {
+		t.Errorf("incorrect CRS, got: %v, want: EPSG:32635.", crs.Name)
+	}
+	georef, err := sut.Georeference("", crs, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same as the pixel scale and tie point as a world file
+	wf, err := NewWorldFile(georef, [2]LatLng{{}, {Lat: 30, Lng: 40}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := WorldFile{A: 2, E: -2, C: 453121, F: 4455799}
+	for i, pair := range [][2]float64{{wf.A, expected.A}, {wf.B, expected.B}, {wf.C, expected.C}, {wf.D, expected.D}, {wf.E, expected.E}, {wf.F, expected.F}} {
+		if !floats.EqualWithinAbs(pair[0], pair[1], 1e-6) {
+			t.Errorf("incorrect parameter %v, got: %v, want: %v.", i, pair[0], pair[1])
+		}
+	}
+
+	// And the image itself can still be read
+	img, format, err := image.Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "tiff" || img.Bounds().Dx() != 40 || img.Bounds().Dy() != 30 {
+		t.Errorf("incorrect image, got: %v %v.", format, img.Bounds())
+	}
+}
+
+func TestGeoTIFFModelTransformation(t *testing.T) {
+	// A rotated lat/lng image, whose raster coordinates are of the centres of
+	// its pixels
+	file := geoTIFFTestFile(t, 40, 30,
+		map[uint16][]float64{
+			tiffModelTransformation: {
+				0.001, 0.0002, 0, 26.2,
+				0.0001, -0.001, 0, 40.3,
+				0, 0, 0, 0,
+				0, 0, 0, 1,
+			},
+		},
+		[][4]uint16{{geoKeyModelType, 0, 1, geoModelGeographic}, {geoKeyRasterType, 0, 1, geoRasterPixelPoint}, {geoKeyGeographicType, 0, 1, 4326}})
+
+	sut, err := ReadGeoTIFF(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crs, err := sut.CRS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	georef, err := sut.Georeference("", crs, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pixel, expected LatLng
+	}{
+		{LatLng{Lat: 0.5, Lng: 0.5}, LatLng{Lat: 40.3, Lng: 26.2}},
+		{LatLng{Lat: 10.5, Lng: 20.5}, LatLng{Lat: 40.3 + 0.002 - 0.01, Lng: 26.2 + 0.02 + 0.002}},
+	}
+	for _, test := range tests {
+		result := georef.GeoFromPixel(test.pixel)
+		if !result.toPoint().IsCloseTo(test.expected.toPoint()) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", test.pixel, result, test.expected)
+		}
+	}
+}
+
+func TestGeoTIFFGCPs(t *testing.T) {
+	var tiepoints []float64
+	for _, rp := range transformationTestReferencePoints() {
+		tiepoints = append(tiepoints, rp.Pixel.Lng, rp.Pixel.Lat, 0, rp.Geographic.Lng, rp.Geographic.Lat, 0)
+	}
+	file := geoTIFFTestFile(t, 40, 30,
+		map[uint16][]float64{tiffModelTiepoint: tiepoints},
+		[][4]uint16{{geoKeyModelType, 0, 1, geoModelGeographic}, {geoKeyGeographicType, 0, 1, 4326}})
+
+	sut, err := ReadGeoTIFF(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	georef, err := sut.Georeference(AffineModel, LatLngCRS, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(georef.ReferencePoints) != len(transformationTestReferencePoints()) {
+		t.Errorf("incorrect number of reference points, got: %v.", len(georef.ReferencePoints))
+	}
+}
+
+func TestBadGeoTIFFs(t *testing.T) {
+	userDefined := geoTIFFTestFile(t, 4, 3,
+		map[uint16][]float64{tiffModelPixelScale: {2, 2, 0}, tiffModelTiepoint: {0, 0, 0, 453120, 4455800, 0}},
+		[][4]uint16{{geoKeyModelType, 0, 1, geoModelProjected}, {geoKeyProjectedType, 0, 1, geoUserDefined}})
+	if g, err := ReadGeoTIFF(bytes.NewReader(userDefined)); err != nil {
+		t.Error(err)
+	} else if _, err := g.CRS(); err == nil {
+		t.Errorf("expected an error for a user defined CRS")
+	}
+
+	for name, file := range map[string][]byte{
+		"not a TIFF": []byte("\xff\xd8\xff\xe0 a JPEG really"),
+		"BigTIFF":    {'I', 'I', 43, 0, 8, 0, 0, 0},
+		"no georef":  geoTIFFTestFile(t, 4, 3, nil, nil),
+		"no scale":   geoTIFFTestFile(t, 4, 3, map[uint16][]float64{tiffModelTiepoint: {0, 0, 0, 453120, 4455800, 0}}, nil),
+		"short":      geoTIFFTestFile(t, 4, 3, map[uint16][]float64{tiffModelTiepoint: {0, 0, 0, 453120}}, nil),
+		"bad matrix": geoTIFFTestFile(t, 4, 3, map[uint16][]float64{tiffModelTransformation: {1, 0, 0, 1}}, nil),
+		"truncated":  geoTIFFTestFile(t, 4, 3, nil, nil)[:8],
+	} {
+		if _, err := ReadGeoTIFF(bytes.NewReader(file)); err == nil {
+			t.Errorf("expected an error for %v", name)
+		}
+	}
+}
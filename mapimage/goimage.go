@@ -1,69 +1,66 @@
 package mapimage
 
 import (
-	"bytes"
-	"golang.org/x/image/draw"
 	"image"
-	"image/color"
 	_ "image/jpeg"
-	"image/png"
 	"io"
 	"log"
-	"math"
 	"os"
+	"time"
 )
 
 type goImage struct {
-	id              string
-	text            string
-	minZoom         int
-	maxZoom         int
-	referencePoints []MapImagePair
-	contents        *os.File
-	image           image.Image
-	toGeo           Transformation
-	toPixel         Transformation
+	id       string
+	text     string
+	minZoom  int
+	maxZoom  int
+	georef   Georeference
+	options  TileOptions
+	contents *os.File
+	image    image.Image
+	// overviews[n] is the image halved n+1 times
+	overviews []image.Image
+}
+
+func init() {
+	RegisterBackend("go", func(id, text string, georef Georeference, options TileOptions, contents *os.File, dir string) (MapImage, error) {
+		return NewImageInfo(id, text, georef, options, contents), nil
+	})
 }
 
 func NewImageInfo(
 	id,
 	text string,
-	referencePoints []MapImagePair,
+	georef Georeference,
+	options TileOptions,
 	contents *os.File) MapImage {
 	image, _, err := image.Decode(contents)
 	if err != nil {
 		panic(0)
 	}
 
-	geo := []Point{referencePoints[0].Geographic.toPoint(), referencePoints[1].Geographic.toPoint()}
-	pixel := []Point{referencePoints[0].Pixel.toPoint(), referencePoints[1].Pixel.toPoint()}
-	toGeo, err := NewAffineNoRotTransformationFromPoints(geo, pixel) //, local)
-	if err != nil {
-		log.Println(err)
-		panic(0)
-	}
-
-	toPixel, err := NewAffineNoRotTransformationFromPoints(pixel, geo) //, local)
-	if err != nil {
-		log.Println(err)
-		panic(0)
-	}
-
 	i := goImage{
-		id:              id,
-		text:            text,
-		minZoom:         0,
-		maxZoom:         0,
-		referencePoints: referencePoints,
-		contents:        contents,
-		image:           image,
-		toGeo:           &toGeo,
-		toPixel:         &toPixel,
+		id:       id,
+		text:     text,
+		minZoom:  0,
+		maxZoom:  0,
+		georef:   georef,
+		options:  options,
+		contents: contents,
+		image:    image,
 	}
 
 	i.minZoom = calculateMinZoom(&i)
 	i.maxZoom = calculateMaxZoom(&i)
 
+	if options.OverviewDir != "" {
+		var modTime time.Time
+		if info, err := contents.Stat(); err == nil {
+			modTime = info.ModTime()
+		}
+		i.overviews = goOverviews(image, options.OverviewDir, modTime)
+	}
+
 	return &i
 }
 
@@ -76,12 +73,7 @@ func (i goImage) Text() string {
 }
 
 func (i goImage) GeoBounds() [2]LatLng {
-	imageSize := i.PixelBounds()
-
-	min := i.GeoFromPixel(imageSize[0])
-	max := i.GeoFromPixel(imageSize[1])
-
-	return [2]LatLng{min, max}
+	return calculateGeoBounds(&i)
 }
 
 func LatLngFromPoint(pt image.Point) LatLng {
@@ -102,69 +94,41 @@ func (i goImage) MaxZoom() int {
 	return i.maxZoom
 }
 
+func (i goImage) Georeference() Georeference {
+	return i.georef
+}
+
+func (i goImage) TileOptions() TileOptions {
+	return i.options
+}
+
 func (i goImage) GeoFromPixel(p LatLng) LatLng {
-	return LatLng(i.toGeo.Project(p.toPoint()))
+	return i.georef.GeoFromPixel(p)
 }
 
 func (i goImage) PixelFromGeo(p LatLng) LatLng {
-	return LatLng(i.toPixel.Project(p.toPoint()))
+	return i.georef.PixelFromGeo(p)
 }
 
 func (ii *goImage) ImageContent() io.ReadSeeker {
 	return ii.contents
 }
 
-func (ii goImage) MapTile(zoom, x, y int64) io.ReadSeeker {
-	tileMin, tileMax := TileLatLonBounds(x, y, zoom)
-	pxlMin := ii.PixelFromGeo(LatLng(tileMin))
-	pxlMax := ii.PixelFromGeo(LatLng(tileMax))
-
-	tileSize := image.Rect(0, 0, 256, 256)
-	img := image.NewRGBA(tileSize)
-	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.ZP, draw.Src)
-
-	tileRect := image.Rect(
-		int(math.Round(pxlMax.Lng)),
-		int(math.Round(pxlMax.Lat)),
-		int(math.Round(pxlMin.Lng)),
-		int(math.Round(pxlMin.Lat)),
-	)
-
-	imgBounds := ii.image.Bounds()
-	if imgBounds.Overlaps(tileRect) {
-		srcRect := image.Rect(
-			max(tileRect.Min.X, imgBounds.Min.X),
-			max(tileRect.Min.Y, imgBounds.Min.Y),
-			min(tileRect.Max.X, imgBounds.Max.X),
-			min(tileRect.Max.Y, imgBounds.Max.Y),
-		)
-
-		dstRect := tileSize
-		if srcRect.Max.X != tileRect.Max.X {
-			// Reduce the right hand side of dstRect by the same ratio
-			dstRect.Max.X -= int(float64(tileSize.Dx()) * (float64(tileRect.Max.X-srcRect.Max.X) / float64(tileRect.Dx())))
-		}
-
-		if srcRect.Min.X != tileRect.Min.X {
-			// Increase the left hand side of dstRect by the same ratio
-			dstRect.Min.X += int(float64(tileSize.Dx()) * (float64(srcRect.Min.X-tileRect.Min.X) / float64(tileRect.Dx())))
-		}
+func (ii goImage) pixelLevels() []image.Image {
+	return append([]image.Image{ii.image}, ii.overviews...)
+}
 
-		if srcRect.Max.Y != tileRect.Max.Y {
-			dstRect.Max.Y -= int(float64(tileSize.Dy()) * (float64(tileRect.Max.Y-srcRect.Max.Y) / float64(tileRect.Dy())))
-		}
+func (ii goImage) MapTile(zoom, x, y int64, format TileFormat) io.ReadSeeker {
+	img := ii.options.emptyTile()
 
-		if srcRect.Min.Y != tileRect.Min.Y {
-			dstRect.Min.Y += int(float64(tileSize.Dy()) * (float64(srcRect.Min.Y-tileRect.Min.Y) / float64(tileRect.Dy())))
-		}
+	// Work backwards from every pixel of the tile, rather than just scaling
+	// a rectangle of the image, so that rotated and warped images line up
+	drawTile(img, ii.image, ii.overviews, zoom, x, y, ii.PixelFromGeo, ii.options.Resampling.Kernel(zoom))
 
-		//scaler := draw.BiLinear
-		//scaler := draw.NearestNeighbor
-		scaler := draw.ApproxBiLinear
-		scaler.Scale(img, dstRect, ii.image, srcRect, draw.Over, nil)
+	tile, err := ii.options.encodeTile(img, format)
+	if err != nil {
+		log.Println("encode tile", err)
+		return colorTile(zoom, x, y)
 	}
-
-	w := bytes.Buffer{}
-	png.Encode(&w, img)
-	return bytes.NewReader(w.Bytes())
+	return tile
 }
@@ -0,0 +1,107 @@
+package mapimage
+
+import (
+	"gonum.org/v1/gonum/mat"
+	"math"
+)
+
+// HelmertTransformation shifts lat/lng (in degrees) between datums, via earth
+// centred coordinates (position vector rotations, as in PROJ's +towgs84)
+type HelmertTransformation struct {
+	from, to Ellipsoid
+	// x' = t + m * x
+	t *mat.VecDense
+	m *mat.Dense
+}
+
+// NewHelmertTransformation takes the translations in metres, the rotations in
+// arc seconds and the scale in parts per million
+func NewHelmertTransformation(from, to Ellipsoid, tx, ty, tz, rx, ry, rz, s float64) HelmertTransformation {
+	arcSecond := math.Pi / (180 * 3600)
+	rx, ry, rz = rx*arcSecond, ry*arcSecond, rz*arcSecond
+	scale := 1 + s*1e-6
+
+	//           --              --
+	//          |  1   -rz   ry   |
+	//  scale * |  rz   1   -rx   |
+	//          | -ry   rx   1    |
+	//           --              --
+	m := mat.NewDense(3, 3, []float64{
+		1, -rz, ry,
+		rz, 1, -rx,
+		-ry, rx, 1,
+	})
+	m.Scale(scale, m)
+
+	return HelmertTransformation{
+		from: from,
+		to:   to,
+		t:    mat.NewVecDense(3, []float64{tx, ty, tz}),
+		m:    m,
+	}
+}
+
+// Inverse is found iteratively, as the heights are dropped
+func (h *HelmertTransformation) Inverse() Transformation {
+	var m mat.Dense
+	// (A small rotation and scale is never singular)
+	m.Inverse(h.m)
+	var t mat.VecDense
+	t.MulVec(&m, h.t)
+	t.ScaleVec(-1, &t)
+
+	approximate := &HelmertTransformation{from: h.to, to: h.from, t: &t, m: &m}
+	inverse := NewIterativeInverseTransformation(h, approximate)
+	return &inverse
+}
+
+func (h *HelmertTransformation) Project(p Point) Point {
+	return h.Projects(p)[0]
+}
+
+func (h *HelmertTransformation) Projects(points ...Point) (results []Point) {
+	for _, p := range points {
+		x, y, z := geodeticToCartesian(h.from, p.Lat, p.Lng)
+
+		var shifted mat.VecDense
+		shifted.MulVec(h.m, mat.NewVecDense(3, []float64{x, y, z}))
+		shifted.AddVec(&shifted, h.t)
+
+		lat, lng := cartesianToGeodetic(h.to, shifted.AtVec(0), shifted.AtVec(1), shifted.AtVec(2))
+		results = append(results, Point{Lat: lat, Lng: lng})
+	}
+	return
+}
+
+// geodeticToCartesian is the earth centred position of lat/lng (in degrees)
+// on the surface of the ellipsoid
+func geodeticToCartesian(e Ellipsoid, lat, lng float64) (x, y, z float64) {
+	phi, lambda := RadFromDeg(lat), RadFromDeg(lng)
+	e2 := e.E2()
+	nu := e.A / math.Sqrt(1-e2*math.Sin(phi)*math.Sin(phi))
+
+	x = nu * math.Cos(phi) * math.Cos(lambda)
+	y = nu * math.Cos(phi) * math.Sin(lambda)
+	z = nu * (1 - e2) * math.Sin(phi)
+	return
+}
+
+// cartesianToGeodetic is the lat/lng (in degrees) of the earth centred
+// position, ignoring its height above the ellipsoid
+func cartesianToGeodetic(e Ellipsoid, x, y, z float64) (lat, lng float64) {
+	e2 := e.E2()
+	p := math.Hypot(x, y)
+
+	phi := math.Atan2(z, p*(1-e2))
+	for i := 0; i < 10; i++ {
+		nu := e.A / math.Sqrt(1-e2*math.Sin(phi)*math.Sin(phi))
+		next := math.Atan2(z+e2*nu*math.Sin(phi), p)
+		if math.Abs(next-phi) < 1e-14 {
+			phi = next
+			break
+		}
+		phi = next
+	}
+
+	return DegFromRad(phi), DegFromRad(math.Atan2(y, x))
+}
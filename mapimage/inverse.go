@@ -0,0 +1,79 @@
+package mapimage
+
+import (
+	"math"
+)
+
+// IterativeInverseTransformation inverts a transformation with Newton's
+// method, starting from where an approximate inverse puts the point
+type IterativeInverseTransformation struct {
+	forward     Transformation
+	approximate Transformation
+}
+
+const (
+	inverseMaxIterations = 20
+	inverseTolerance     = 1e-9
+)
+
+func NewIterativeInverseTransformation(forward, approximate Transformation) IterativeInverseTransformation {
+	return IterativeInverseTransformation{forward: forward, approximate: approximate}
+}
+
+func (t *IterativeInverseTransformation) Inverse() Transformation {
+	return t.forward
+}
+
+// linearPart is the affine of just the constant and linear terms, on points
+// normalised as (x - offset) / scale
+func linearPart(offset Point, scale float64, e0, ex, ey, n0, nx, ny float64) AffineTransformation {
+	return NewAffineTransformation(
+		ex/scale, ey/scale,
+		nx/scale, ny/scale,
+		e0-(ex*offset.Lng+ey*offset.Lat)/scale,
+		n0-(nx*offset.Lng+ny*offset.Lat)/scale,
+	)
+}
+
+func (t *IterativeInverseTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *IterativeInverseTransformation) Projects(points ...Point) (results []Point) {
+	for i, guess := range t.approximate.Projects(points...) {
+		results = append(results, t.solve(points[i], guess))
+	}
+	return
+}
+
+func (t *IterativeInverseTransformation) solve(target, x Point) Point {
+	for iter := 0; iter < inverseMaxIterations; iter++ {
+		// The jacobian of the forward transformation at x, by finite differences
+		h := 1e-6 * math.Max(1, math.Max(math.Abs(x.Lat), math.Abs(x.Lng)))
+		projected := t.forward.Projects(x, Point{Lat: x.Lat, Lng: x.Lng + h}, Point{Lat: x.Lat + h, Lng: x.Lng})
+		f, fx, fy := projected[0], projected[1], projected[2]
+
+		//   --    --     --                              --
+		//  | a  b  |    | dE/dx (Lng)    dE/dy (Lat)     |
+		//  |       | =  |                                |
+		//  | c  d  |    | dN/dx (Lng)    dN/dy (Lat)     |
+		//   --    --     --                              --
+		a, b := (fx.Lng-f.Lng)/h, (fy.Lng-f.Lng)/h
+		c, d := (fx.Lat-f.Lat)/h, (fy.Lat-f.Lat)/h
+		det := a*d - b*c
+		if det == 0 {
+			break
+		}
+
+		errE, errN := f.Lng-target.Lng, f.Lat-target.Lat
+		dx := (d*errE - b*errN) / det
+		dy := (-c*errE + a*errN) / det
+		x.Lng -= dx
+		x.Lat -= dy
+
+		if math.Hypot(dx, dy) < inverseTolerance*math.Max(1, math.Hypot(x.Lat, x.Lng)) {
+			break
+		}
+	}
+	return x
+}
@@ -0,0 +1,102 @@
+package mapimage
+
+import (
+	"math"
+)
+
+// LambertConformalConicTransformation projects lat/lng (in degrees) to
+// eastings and northings in metres, e.g. France's Lambert-93
+type LambertConformalConicTransformation struct {
+	ellipsoid     Ellipsoid
+	lon0          float64
+	falseEasting  float64
+	falseNorthing float64
+	inverse       bool
+
+	// Derived from the standard parallels etc
+	n, aF, rF float64
+}
+
+// NewLambertConformalConicTransformation has two standard parallels (lat1 and
+// lat2), where the scale is true
+func NewLambertConformalConicTransformation(ellipsoid Ellipsoid, lat0, lon0, lat1, lat2, falseEasting, falseNorthing float64) LambertConformalConicTransformation {
+	return newLambertConformalConic(ellipsoid, lat0, lon0, lat1, lat2, 1, falseEasting, falseNorthing)
+}
+
+// NewLambertConformalConic1SPTransformation has a single standard parallel
+// (lat0), with a scale factor of k0 along it
+func NewLambertConformalConic1SPTransformation(ellipsoid Ellipsoid, lat0, lon0, k0, falseEasting, falseNorthing float64) LambertConformalConicTransformation {
+	return newLambertConformalConic(ellipsoid, lat0, lon0, lat0, lat0, k0, falseEasting, falseNorthing)
+}
+
+func newLambertConformalConic(ellipsoid Ellipsoid, lat0, lon0, lat1, lat2, k0, falseEasting, falseNorthing float64) LambertConformalConicTransformation {
+	e := ellipsoid.E()
+	m := func(phi float64) float64 {
+		return math.Cos(phi) / math.Sqrt(1-ellipsoid.E2()*math.Sin(phi)*math.Sin(phi))
+	}
+
+	phi0, phi1, phi2 := RadFromDeg(lat0), RadFromDeg(lat1), RadFromDeg(lat2)
+	m1, t1 := m(phi1), isometricT(phi1, e)
+
+	n := math.Sin(phi1)
+	if lat1 != lat2 {
+		m2, t2 := m(phi2), isometricT(phi2, e)
+		n = (math.Log(m1) - math.Log(m2)) / (math.Log(t1) - math.Log(t2))
+	}
+	aF := ellipsoid.A * k0 * m1 / (n * math.Pow(t1, n))
+
+	return LambertConformalConicTransformation{
+		ellipsoid:     ellipsoid,
+		lon0:          lon0,
+		falseEasting:  falseEasting,
+		falseNorthing: falseNorthing,
+		n:             n,
+		aF:            aF,
+		rF:            aF * math.Pow(isometricT(phi0, e), n),
+	}
+}
+
+func (t *LambertConformalConicTransformation) forward(lat, lng float64) (easting, northing float64) {
+	r := t.aF * math.Pow(isometricT(RadFromDeg(lat), t.ellipsoid.E()), t.n)
+	theta := t.n * RadFromDeg(lng-t.lon0)
+	easting = t.falseEasting + r*math.Sin(theta)
+	northing = t.falseNorthing + t.rF - r*math.Cos(theta)
+	return
+}
+
+func (t *LambertConformalConicTransformation) backward(easting, northing float64) (lat, lng float64) {
+	dE := easting - t.falseEasting
+	dN := t.rF - (northing - t.falseNorthing)
+	sign := math.Copysign(1, t.n)
+
+	r := sign * math.Hypot(dE, dN)
+	theta := math.Atan2(sign*dE, sign*dN)
+	tt := math.Pow(r/t.aF, 1/t.n)
+
+	lat = DegFromRad(phiFromT(tt, t.ellipsoid.E()))
+	lng = t.lon0 + DegFromRad(theta/t.n)
+	return
+}
+
+func (t *LambertConformalConicTransformation) Inverse() Transformation {
+	inverse := *t
+	inverse.inverse = !t.inverse
+	return &inverse
+}
+
+func (t *LambertConformalConicTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *LambertConformalConicTransformation) Projects(points ...Point) (results []Point) {
+	for _, p := range points {
+		if t.inverse {
+			lat, lng := t.backward(p.Lng, p.Lat)
+			results = append(results, Point{Lat: lat, Lng: lng})
+		} else {
+			easting, northing := t.forward(p.Lat, p.Lng)
+			results = append(results, PointEastingNorthing(easting, northing))
+		}
+	}
+	return
+}
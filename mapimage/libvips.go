@@ -1,20 +1,26 @@
+//go:build vips && cgo
+// +build vips,cgo
+
 package mapimage
 
 import (
 	"bytes"
+	"errors"
 	"github.com/h2non/bimg"
-	"golang.org/x/image/draw"
 	"image"
-	"image/color"
 	_ "image/jpeg"
 	"image/png"
 	"io"
 	"io/ioutil"
 	"log"
-	"math"
 	"os"
+	"time"
 )
 
+func init() {
+	RegisterBackend("vips", NewVIPSImageInfo)
+}
+
 type libvipsImage struct {
 	id      string
 	text    string
@@ -22,60 +28,122 @@ type libvipsImage struct {
 	maxZoom int
 
 	contents    *os.File
-	fileBuf     []byte
 	imageConfig image.Config
 	imageFormat string
-	toGeo       Transformation
-	toPixel     Transformation
+	georef      Georeference
+	options     TileOptions
+	// Where the chunk stores and the temporary PNGs go
+	dir string
+	// The image converted to a chunk store, and its overviews (overviews[n]
+	// being it halved n+1 times)
+	store     *chunkStore
+	overviews []image.Image
 }
 
+// NewVIPSImageInfo is a MapImage that libvips converts (the once) into chunk
+// stores in dir
 func NewVIPSImageInfo(
 	id,
 	text string,
-	referencePoints []MapImagePair,
-	contents *os.File) MapImage {
+	georef Georeference,
+	options TileOptions,
+	contents *os.File,
+	dir string) (MapImage, error) {
+	if dir == "" {
+		return nil, errors.New("vips: no directory for the chunk stores")
+	}
 	imageConfig, format, err := image.DecodeConfig(contents)
 	if err != nil {
-		panic(0)
+		return nil, err
 	}
 
-	geo := []Point{referencePoints[0].Geographic.toPoint(), referencePoints[1].Geographic.toPoint()}
-	pixel := []Point{referencePoints[0].Pixel.toPoint(), referencePoints[1].Pixel.toPoint()}
+	i := libvipsImage{
+		id:          id,
+		text:        text,
+		contents:    contents,
+		imageConfig: imageConfig,
+		imageFormat: format,
+		georef:      georef,
+		options:     options,
+		dir:         dir,
+	}
+	i.minZoom = calculateMinZoom(&i)
+	i.maxZoom = calculateMaxZoom(&i)
 
-	toGeo, err := NewAffineNoRotTransformationFromPoints(geo, pixel) //, local)
+	var modTime time.Time
+	if info, err := contents.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+	i.store, i.overviews, err = loadChunkPyramid(dir, options.OverviewDir, modTime, i.buildLevel)
 	if err != nil {
-		log.Println(err)
-		panic(0)
+		return nil, err
 	}
+	return &i, nil
+}
+
+// vipsShrinkOnLoad is the first overview that libvips shrinks from the image
+// (a JPEG loads at up to 1/8 of the size), rather than halving the last
+const vipsShrinkOnLoad = 3
+
+// tempPNG is a PNG read from a temporary file, which is removed when it is
+// closed
+type tempPNG struct {
+	*pngStream
+	f *os.File
+}
+
+func (t tempPNG) Close() error {
+	t.f.Close()
+	return os.Remove(t.f.Name())
+}
 
-	toPixel, err := NewAffineNoRotTransformationFromPoints(pixel, geo) //, local)
+// vipsPNG has libvips write the image file as a PNG (with convert), into a
+// temporary file that it is then read from a band at a time
+func (ii *libvipsImage) vipsPNG(convert func(in, out string) error) (image.Image, error) {
+	if err := os.MkdirAll(ii.dir, 0777); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(ii.dir, "vips*.png")
 	if err != nil {
-		log.Println(err)
-		panic(0)
+		return nil, err
 	}
+	t := tempPNG{f: f}
+	if err := convert(ii.contents.Name(), f.Name()); err != nil {
+		t.Close()
+		return nil, err
+	}
+	if t.pngStream, err = newPNGStream(f); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
 
-	contents.Seek(0, 0)
-	buf, err := ioutil.ReadAll(contents)
-	if err != nil {
-		panic(0)
+// buildLevel is the level as a streamed PNG: the file itself, or one that
+// libvips writes
+func (ii *libvipsImage) buildLevel(level int, previous *chunkStore) (image.Image, error) {
+	if level == 0 {
+		if ii.imageFormat == "png" {
+			if _, err := ii.contents.Seek(0, 0); err != nil {
+				return nil, err
+			}
+			if s, err := newPNGStream(ii.contents); err == nil {
+				return s, nil
+			}
+		}
+		return ii.vipsPNG(vipsFileToPNG)
+	}
+	if level < vipsShrinkOnLoad {
+		return halfImage{previous}, nil
 	}
 
-	i := libvipsImage{
-		id:      id,
-		text:    text,
-		minZoom: 0,
-		maxZoom: 0,
-		//referencePoints: referencePoints,
-		contents:    contents,
-		fileBuf:     buf,
-		imageConfig: imageConfig,
-		imageFormat: format,
-		toGeo:       &toGeo,
-		toPixel:     &toPixel,
+	width, height := ii.imageConfig.Width, ii.imageConfig.Height
+	for n := 0; n < level; n++ {
+		width, height = (width+1)/2, (height+1)/2
 	}
-	i.minZoom = calculateMinZoom(&i)
-	i.maxZoom = calculateMaxZoom(&i)
-	return &i
+	return ii.vipsPNG(func(in, out string) error {
+		return vipsShrinkToPNG(in, out, width, height)
+	})
 }
 
 func (i libvipsImage) Id() string {
@@ -85,12 +153,7 @@ func (i libvipsImage) Text() string {
 	return i.text
 }
 func (i libvipsImage) GeoBounds() [2]LatLng {
-	imageSize := i.PixelBounds()
-
-	min := i.GeoFromPixel(imageSize[0])
-	max := i.GeoFromPixel(imageSize[1])
-
-	return [2]LatLng{min, max}
+	return calculateGeoBounds(&i)
 }
 
 func (i libvipsImage) PixelBounds() [2]LatLng {
@@ -108,95 +171,53 @@ func (i libvipsImage) MaxZoom() int {
 	return i.maxZoom
 }
 
+func (i libvipsImage) Georeference() Georeference {
+	return i.georef
+}
+
+func (i libvipsImage) TileOptions() TileOptions {
+	return i.options
+}
+
 func (i libvipsImage) GeoFromPixel(p LatLng) LatLng {
-	return LatLng(i.toGeo.Project(p.toPoint()))
+	return i.georef.GeoFromPixel(p)
 }
 
 func (i libvipsImage) PixelFromGeo(p LatLng) LatLng {
-	return LatLng(i.toPixel.Project(p.toPoint()))
+	return i.georef.PixelFromGeo(p)
 }
 
 func (ii *libvipsImage) ImageContent() io.ReadSeeker {
 	return ii.contents
 }
 
-func (ii libvipsImage) MapTile(zoom, x, y int64) io.ReadSeeker {
-	tileMin, tileMax := TileLatLonBounds(x, y, zoom)
-	pxlMin := ii.PixelFromGeo(LatLng(tileMin))
-	pxlMax := ii.PixelFromGeo(LatLng(tileMax))
-
-	tileSize := image.Rect(0, 0, 256, 256)
-	tileRect := image.Rect(
-		int(math.Round(pxlMax.Lng)),
-		int(math.Round(pxlMax.Lat)),
-		int(math.Round(pxlMin.Lng)),
-		int(math.Round(pxlMin.Lat)),
-	)
-
-	imgBounds := image.Rect(0, 0, ii.imageConfig.Width, ii.imageConfig.Height)
-	if !imgBounds.Overlaps(tileRect) {
-		img := image.NewRGBA(tileSize)
-		draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.ZP, draw.Src)
-		w := bytes.Buffer{}
-		png.Encode(&w, img)
-		return bytes.NewReader(w.Bytes())
-	}
-
-	srcRect := image.Rect(
-		max(tileRect.Min.X, imgBounds.Min.X),
-		max(tileRect.Min.Y, imgBounds.Min.Y),
-		min(tileRect.Max.X, imgBounds.Max.X),
-		min(tileRect.Max.Y, imgBounds.Max.Y),
-	)
-
-	dstRect := tileSize
-	if srcRect.Max.X != tileRect.Max.X {
-		// Reduce the right hand side of dstRect by the same ratio
-		dstRect.Max.X -= int(float64(tileSize.Dx()) * (float64(tileRect.Max.X-srcRect.Max.X) / float64(tileRect.Dx())))
-	}
-
-	if srcRect.Min.X != tileRect.Min.X {
-		// Increase the left hand side of dstRect by the same ratio
-		dstRect.Min.X += int(float64(tileSize.Dx()) * (float64(srcRect.Min.X-tileRect.Min.X) / float64(tileRect.Dx())))
-	}
-
-	if srcRect.Max.Y != tileRect.Max.Y {
-		dstRect.Max.Y -= int(float64(tileSize.Dy()) * (float64(tileRect.Max.Y-srcRect.Max.Y) / float64(tileRect.Dy())))
-	}
-
-	if srcRect.Min.Y != tileRect.Min.Y {
-		dstRect.Min.Y += int(float64(tileSize.Dy()) * (float64(srcRect.Min.Y-tileRect.Min.Y) / float64(tileRect.Dy())))
-	}
-
-	imgObj := bimg.NewImage(ii.fileBuf)
-	_, err := imgObj.Extract(srcRect.Min.Y, srcRect.Min.X, srcRect.Dx(), srcRect.Dy())
-
-	if err != nil {
-		log.Println("extract image", srcRect, err)
-		return colorTile(zoom, x, y)
-	}
+func (ii libvipsImage) pixelLevels() []image.Image {
+	return append([]image.Image{ii.store}, ii.overviews...)
+}
 
-	newImage, err := imgObj.ForceResize(dstRect.Dx(), dstRect.Dy())
-	if err != nil {
-		log.Println("resize image", err)
-		return colorTile(zoom, x, y)
-	}
+// MapTile draws the tile from the chunk stores
+func (ii libvipsImage) MapTile(zoom, x, y int64, format TileFormat) io.ReadSeeker {
+	img := ii.options.emptyTile()
+	drawTile(img, ii.store, ii.overviews, zoom, x, y, ii.PixelFromGeo, ii.options.Resampling.Kernel(zoom))
 
-	// change into an in-memory golang image (after a libvips one)
-	srcImage, _, err := image.Decode(bytes.NewReader(newImage))
+	tile, err := ii.options.encodeTile(img, format)
 	if err != nil {
-		log.Println("decode image", err)
+		log.Println("encode tile", err)
 		return colorTile(zoom, x, y)
 	}
+	return tile
+}
 
-	img := image.NewRGBA(tileSize)
-	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.ZP, draw.Src)
-
-	scaler := draw.ApproxBiLinear
-	scaler.Scale(img, dstRect, srcImage, srcImage.Bounds(), draw.Over, nil)
+// webPSupported is whether libvips can save WebP, for the tiles
+func webPSupported() bool {
+	return bimg.VipsIsTypeSupportedSave(bimg.WEBP)
+}
 
+// encodeWebP has libvips convert the tile (via a PNG, to keep the alpha)
+func encodeWebP(img image.Image, quality int) ([]byte, error) {
 	w := bytes.Buffer{}
-	png.Encode(&w, img)
-
-	return bytes.NewReader(w.Bytes())
+	if err := png.Encode(&w, img); err != nil {
+		return nil, err
+	}
+	return bimg.NewImage(w.Bytes()).Process(bimg.Options{Type: bimg.WEBP, Quality: quality})
 }
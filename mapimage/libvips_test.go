@@ -0,0 +1,75 @@
+//go:build vips && cgo
+// +build vips,cgo
+
+package mapimage
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVIPSImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Big enough for overviews that are shrunk straight from the JPEG
+	src := image.NewRGBA(image.Rect(0, 0, 2100, 1500))
+	for y := 0; y < 1500; y++ {
+		for x := 0; x < 2100; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(x / 10), G: uint8(y / 10), B: 100, A: 255})
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, "map.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := jpeg.Encode(f, src, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, 0)
+
+	original := testMapImage(t, TileOptions{})
+	options := TileOptions{OverviewDir: filepath.Join(dir, "overviews")}
+	mi, err := NewVIPSImageInfo("vips", "VIPS", original.Georeference(), options, f, filepath.Join(dir, "chunks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sut := mi.(*libvipsImage)
+
+	// Each level is the one before it halved, rounding up
+	levels := sut.pixelLevels()
+	if len(levels) != 5 {
+		t.Fatalf("incorrect, got: %v levels.", len(levels))
+	}
+	for l := 1; l < len(levels); l++ {
+		if expected := (halfImage{levels[l-1]}).Bounds(); levels[l].Bounds() != expected {
+			t.Errorf("incorrect for level %v, got: %v, want: %v.", l, levels[l].Bounds(), expected)
+		}
+	}
+	for l, level := range levels {
+		b := level.Bounds()
+		result := color.RGBAModel.Convert(level.At(b.Dx()/2, b.Dy()/2)).(color.RGBA)
+		if int(result.R)-105 > 3 || 105-int(result.R) > 3 || int(result.G)-75 > 3 || 75-int(result.G) > 3 {
+			t.Errorf("incorrect for the middle of level %v, got: %v, want: about {105 75 100 255}.", l, result)
+		}
+	}
+
+	// Nothing is left behind but the chunk stores
+	names, err := filepath.Glob(filepath.Join(dir, "chunks", "*.png"))
+	if err != nil || len(names) != 0 {
+		t.Errorf("incorrect, got: %v %v.", names, err)
+	}
+
+	if _, err := NewVIPSImageInfo("vips", "VIPS", original.Georeference(), options, f, ""); err == nil {
+		t.Error("expected an error without a directory")
+	}
+}
@@ -1,10 +1,12 @@
 package mapimage
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
 	"io"
+	"log"
 	"math"
 	"net/http"
 	"strconv"
@@ -14,7 +16,8 @@ import (
 
 type MapImage interface {
 	ImageContent() io.ReadSeeker
-	MapTile(zoom, x, y int64) io.ReadSeeker
+	// The tile encoded in the format (the image's own when it is "")
+	MapTile(zoom, x, y int64, format TileFormat) io.ReadSeeker
 	Id() string
 	Text() string
 	GeoBounds() [2]LatLng
@@ -23,7 +26,9 @@ type MapImage interface {
 	MinZoom() int
 	// The zoom where image is being stretched by more than half?
 	MaxZoom() int
-	//ReferencePoints() []MapImagePair
+	Georeference() Georeference
+	// How the map tiles are drawn, e.g. the colour outside of the image
+	TileOptions() TileOptions
 	GeoFromPixel(p LatLng) LatLng
 	PixelFromGeo(p LatLng) LatLng
 }
@@ -47,6 +52,32 @@ func (ll LatLng) toPoint() Point {
 	return Point{Lat: ll.Lat, Lng: ll.Lng}
 }
 
+// UnmarshalJSON also accepts an easting and northing (e.g. for a reference
+// point in a projected CRS), which are kept as the Lng and Lat respectively
+func (ll *LatLng) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Lat      *float64 `json:"lat"`
+		Lng      *float64 `json:"lng"`
+		Easting  *float64 `json:"easting"`
+		Northing *float64 `json:"northing"`
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&v); err != nil {
+		return err
+	}
+
+	switch {
+	case v.Lat != nil && v.Lng != nil && v.Easting == nil && v.Northing == nil:
+		ll.Lat, ll.Lng = *v.Lat, *v.Lng
+	case v.Easting != nil && v.Northing != nil && v.Lat == nil && v.Lng == nil:
+		ll.Lat, ll.Lng = *v.Northing, *v.Easting
+	default:
+		return fmt.Errorf("expected either lat and lng, or easting and northing, got: %s", b)
+	}
+	return nil
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -61,6 +92,28 @@ func max(a, b int) int {
 	return b
 }
 
+// calculateGeoBounds is the north west and south east corners of the image's edges
+func calculateGeoBounds(i MapImage) [2]LatLng {
+	const steps = 8
+	pixelBounds := i.PixelBounds()
+	minPx, maxPx := pixelBounds[0], pixelBounds[1]
+
+	nw := LatLng{Lat: math.Inf(-1), Lng: math.Inf(1)}
+	se := LatLng{Lat: math.Inf(1), Lng: math.Inf(-1)}
+	for s := 0; s <= steps; s++ {
+		f := float64(s) / steps
+		x := minPx.Lng + f*(maxPx.Lng-minPx.Lng)
+		y := minPx.Lat + f*(maxPx.Lat-minPx.Lat)
+		for _, p := range []LatLng{{Lat: minPx.Lat, Lng: x}, {Lat: maxPx.Lat, Lng: x}, {Lat: y, Lng: minPx.Lng}, {Lat: y, Lng: maxPx.Lng}} {
+			g := i.GeoFromPixel(p)
+			nw.Lat, nw.Lng = math.Max(nw.Lat, g.Lat), math.Min(nw.Lng, g.Lng)
+			se.Lat, se.Lng = math.Min(se.Lat, g.Lat), math.Max(se.Lng, g.Lng)
+		}
+	}
+
+	return [2]LatLng{nw, se}
+}
+
 func calculateMinZoom(i MapImage) int {
 	// The zoom where whole image is on a single tile?
 	geoBounds := i.GeoBounds()
@@ -107,6 +160,10 @@ type ApiRepresentation struct {
 	PixelBounds [2]LatLng `json:"pixel_bounds"`
 	MinZoom     int       `json:"minZoom"`
 	MaxZoom     int       `json:"maxZoom"`
+	// The model used to georeference the image
+	Transformation string `json:"transformation"`
+	// Reference points that were left out, for not agreeing with the others
+	Outliers []MapImagePair `json:"outliers"`
 	//ReferencePoints []MapImagePair `json:"referencePoints"`
 
 	Image string `json:"image"`
@@ -123,6 +180,9 @@ func ToApi(imagePathBase string, i MapImage) ApiRepresentation {
 		PixelBounds: i.PixelBounds(),
 		MinZoom:     i.MinZoom(),
 		MaxZoom:     i.MaxZoom(),
+
+		Transformation: i.Georeference().Transformation,
+		Outliers:       append(make([]MapImagePair, 0), i.Georeference().Outliers...),
 		//ReferencePoints: i.ReferencePoints(),
 	}
 
@@ -171,6 +231,142 @@ func AttachApi(source MapImagesSource, router *mux.Router, infoPath, imagePathBa
 				}
 			}))
 
+	router.Handle(
+		fmt.Sprintf("%s/{id}/georef", infoPath), http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				vars := mux.Vars(r)
+				id := strings.TrimSpace(vars["id"])
+				if id == "" {
+					http.Error(w, "empty id supplied", http.StatusBadRequest)
+					return
+				}
+
+				if ii, err := source.GetById(id); err == nil {
+					b, err := json.Marshal(NewGeoreferenceReport(ii))
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusOK)
+					w.Write(b)
+				} else {
+					http.Error(w, "Not found", http.StatusNotFound)
+				}
+			}))
+
+	router.Handle(
+		fmt.Sprintf("%s/{id}/worldfile", infoPath), http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				vars := mux.Vars(r)
+				id := strings.TrimSpace(vars["id"])
+				if id == "" {
+					http.Error(w, "empty id supplied", http.StatusBadRequest)
+					return
+				}
+
+				if ii, err := source.GetById(id); err == nil {
+					wf, err := NewWorldFile(ii.Georeference(), ii.PixelBounds())
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					w.Header().Set("Content-Type", "text/plain")
+					w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".wld"))
+					w.WriteHeader(http.StatusOK)
+					wf.Write(w)
+				} else {
+					http.Error(w, "Not found", http.StatusNotFound)
+				}
+			}))
+
+	router.Handle(
+		fmt.Sprintf("%s/{id}/cog", infoPath), http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				vars := mux.Vars(r)
+				id := strings.TrimSpace(vars["id"])
+				if id == "" {
+					http.Error(w, "empty id supplied", http.StatusBadRequest)
+					return
+				}
+
+				if ii, err := source.GetById(id); err == nil {
+					options := COGOptions{WebMercator: r.URL.Query().Get("crs") == "EPSG:3857"}
+					cog, err := NewCOG(ii, options)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					defer cog.Close()
+					w.Header().Set("Content-Type", "image/tiff")
+					w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".tif"))
+					w.Header().Set("Content-Length", strconv.FormatInt(cog.Size(), 10))
+					w.WriteHeader(http.StatusOK)
+					if err := cog.Write(w); err != nil {
+						log.Println("write cog", err)
+					}
+				} else {
+					http.Error(w, "Not found", http.StatusNotFound)
+				}
+			}))
+
+	router.Handle(
+		fmt.Sprintf("%s/{id}/points", infoPath), http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				vars := mux.Vars(r)
+				id := strings.TrimSpace(vars["id"])
+				if id == "" {
+					http.Error(w, "empty id supplied", http.StatusBadRequest)
+					return
+				}
+
+				if ii, err := source.GetById(id); err == nil {
+					var buf bytes.Buffer
+					if err := WriteQGISPoints(&buf, ii); err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					w.Header().Set("Content-Type", "text/plain")
+					w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".points"))
+					w.WriteHeader(http.StatusOK)
+					w.Write(buf.Bytes())
+				} else {
+					http.Error(w, "Not found", http.StatusNotFound)
+				}
+			}))
+
+	router.Handle(
+		fmt.Sprintf("%s/{id}/vrt", infoPath), http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				vars := mux.Vars(r)
+				id := strings.TrimSpace(vars["id"])
+				if id == "" {
+					http.Error(w, "empty id supplied", http.StatusBadRequest)
+					return
+				}
+
+				if ii, err := source.GetById(id); err == nil {
+					// GDAL reads the image straight from the raw endpoint
+					scheme := "http"
+					if r.TLS != nil {
+						scheme = "https"
+					}
+					raw := fmt.Sprintf("/vsicurl/%s://%s/%s", scheme, r.Host, ToApi(imagePathBase, ii).Image)
+
+					var buf bytes.Buffer
+					if err := WriteVRT(&buf, ii, raw); err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					w.Header().Set("Content-Type", "application/xml")
+					w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".vrt"))
+					w.WriteHeader(http.StatusOK)
+					w.Write(buf.Bytes())
+				} else {
+					http.Error(w, "Not found", http.StatusNotFound)
+				}
+			}))
+
 	router.Handle(
 		fmt.Sprintf("%s/raw/{id}", imagePathBase), http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
@@ -205,19 +401,39 @@ func AttachApi(source MapImagesSource, router *mux.Router, infoPath, imagePathBa
 					return
 				}
 
+				// {y}.png, {y}.jpg or {y}.webp picks the format, otherwise it
+				// is the image's own (if the Accept header allows it)
+				yName, ext := vars["y"], ""
+				if dot := strings.Index(yName, "."); dot >= 0 {
+					yName, ext = yName[:dot], yName[dot+1:]
+				}
+				format := NegotiateTileFormat(r.Header.Get("Accept"), ii.TileOptions().format())
+				if ext != "" {
+					if format, err = ParseTileFormat(ext); err != nil {
+						http.Error(w, err.Error(), http.StatusNotFound)
+						return
+					}
+				} else {
+					w.Header().Set("Vary", "Accept")
+				}
+				if !format.Supported() {
+					http.Error(w, fmt.Sprintf("%v tiles aren't supported", format), http.StatusNotFound)
+					return
+				}
+
 				zoom, _ := strconv.ParseInt(vars["z"], 10, 64)
 				x, _ := strconv.ParseInt(vars["x"], 10, 64)
-				y, _ := strconv.ParseInt(vars["y"], 10, 64)
+				y, _ := strconv.ParseInt(yName, 10, 64)
 
 				if tileFmt == "tms" {
 					x, y, zoom = GoogleTile(x, y, zoom)
 				}
 
 				//tile := colorTile(zoom, x, y)
-				tile := ii.MapTile(zoom, x, y)
+				tile := ii.MapTile(zoom, x, y, format)
 
 				w.Header().Set("Expires", "Sun, 17 Jan 2038 19:14:07 GMT")
-				w.Header().Set("Content-Type", "image/png")
-				http.ServeContent(w, r, "huh.png", time.Time{}, tile)
+				w.Header().Set("Content-Type", format.ContentType())
+				http.ServeContent(w, r, "tile."+format.Extension(), time.Time{}, tile)
 			}))
 }
@@ -0,0 +1,40 @@
+package mapimage
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// testMapImage is a 600x400 image (as a PNG file, removed after the test),
+// georeferenced in UTM with 2m pixels
+func testMapImage(t *testing.T, options TileOptions) MapImage {
+	img := image.NewNRGBA(image.Rect(0, 0, 600, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 600; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x + y), A: 255})
+		}
+	}
+	f, err := ioutil.TempFile("", "mapimage*.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, 0)
+
+	crs, err := ParseCRS("EPSG:32635")
+	if err != nil {
+		t.Fatal(err)
+	}
+	toCRS := WorldFile{A: 2, E: -2, C: 453121, F: 4455799}.Transformation()
+	return NewImageInfo("test", "Test", NewGeoreferenceFromTransformation(AffineModel, crs, &toCRS), options, f)
+}
@@ -0,0 +1,54 @@
+package mapimage
+
+import (
+	"math"
+)
+
+// MercatorTransformation is the ellipsoidal Mercator projection, e.g. World
+// Mercator (EPSG:3395), not the spherical Web Mercator
+type MercatorTransformation struct {
+	ellipsoid     Ellipsoid
+	lon0          float64
+	k0            float64
+	falseEasting  float64
+	falseNorthing float64
+	inverse       bool
+}
+
+func NewMercatorTransformation(ellipsoid Ellipsoid, lon0, k0, falseEasting, falseNorthing float64) MercatorTransformation {
+	return MercatorTransformation{
+		ellipsoid:     ellipsoid,
+		lon0:          lon0,
+		k0:            k0,
+		falseEasting:  falseEasting,
+		falseNorthing: falseNorthing,
+	}
+}
+
+func (t *MercatorTransformation) Inverse() Transformation {
+	inverse := *t
+	inverse.inverse = !t.inverse
+	return &inverse
+}
+
+func (t *MercatorTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *MercatorTransformation) Projects(points ...Point) (results []Point) {
+	ak := t.ellipsoid.A * t.k0
+	e := t.ellipsoid.E()
+	for _, p := range points {
+		if t.inverse {
+			tt := math.Exp(-(p.Lat - t.falseNorthing) / ak)
+			lat := DegFromRad(phiFromT(tt, e))
+			lng := t.lon0 + DegFromRad((p.Lng-t.falseEasting)/ak)
+			results = append(results, Point{Lat: lat, Lng: lng})
+		} else {
+			easting := t.falseEasting + ak*RadFromDeg(p.Lng-t.lon0)
+			northing := t.falseNorthing - ak*math.Log(isometricT(RadFromDeg(p.Lat), e))
+			results = append(results, PointEastingNorthing(easting, northing))
+		}
+	}
+	return
+}
@@ -0,0 +1,55 @@
+package mapimage
+
+import (
+	"math"
+)
+
+// MolodenskyTransformation shifts lat/lng (in degrees) between datums by just
+// a translation (in metres), without going via earth centred coordinates
+type MolodenskyTransformation struct {
+	from, to   Ellipsoid
+	dx, dy, dz float64
+}
+
+func NewMolodenskyTransformation(from, to Ellipsoid, dx, dy, dz float64) MolodenskyTransformation {
+	return MolodenskyTransformation{from: from, to: to, dx: dx, dy: dy, dz: dz}
+}
+
+// Inverse is found iteratively, starting from the shift the other way (which
+// is only the inverse to within a few centimetres)
+func (t *MolodenskyTransformation) Inverse() Transformation {
+	approximate := NewMolodenskyTransformation(t.to, t.from, -t.dx, -t.dy, -t.dz)
+	inverse := NewIterativeInverseTransformation(t, &approximate)
+	return &inverse
+}
+
+func (t *MolodenskyTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *MolodenskyTransformation) Projects(points ...Point) (results []Point) {
+	a, f, e2 := t.from.A, t.from.F(), t.from.E2()
+	da, df := t.to.A-a, t.to.F()-f
+	b := a * (1 - f)
+
+	for _, p := range points {
+		phi, lambda := RadFromDeg(p.Lat), RadFromDeg(p.Lng)
+		sinPhi, cosPhi := math.Sin(phi), math.Cos(phi)
+		sinLambda, cosLambda := math.Sin(lambda), math.Cos(lambda)
+
+		w := 1 - e2*sinPhi*sinPhi
+		rn := a / math.Sqrt(w)                // prime vertical radius of curvature
+		rm := a * (1 - e2) / math.Pow(w, 1.5) // meridian radius of curvature
+
+		dPhi := (-t.dx*sinPhi*cosLambda - t.dy*sinPhi*sinLambda + t.dz*cosPhi +
+			da*(rn*e2*sinPhi*cosPhi)/a +
+			df*(rm*a/b+rn*b/a)*sinPhi*cosPhi) / rm
+		dLambda := (-t.dx*sinLambda + t.dy*cosLambda) / (rn * cosPhi)
+
+		results = append(results, Point{
+			Lat: p.Lat + DegFromRad(dPhi),
+			Lng: p.Lng + DegFromRad(dLambda),
+		})
+	}
+	return
+}
@@ -0,0 +1,19 @@
+//go:build !vips || !cgo
+// +build !vips !cgo
+
+package mapimage
+
+import (
+	"errors"
+	"image"
+)
+
+// Without libvips there is no "vips" backend, and no WebP tiles
+
+func webPSupported() bool {
+	return false
+}
+
+func encodeWebP(img image.Image, quality int) ([]byte, error) {
+	return nil, errors.New("built without libvips")
+}
@@ -0,0 +1,180 @@
+package mapimage
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Overview level n is the image halved n times, kept in {dir}/{n}.png
+
+// overviewLevels is how many times an image of the size is halved, before it
+// fits on a tile
+func overviewLevels(width, height int) int {
+	levels := 0
+	for size := max(width, height); size > int(tileSize); size = (size + 1) / 2 {
+		levels++
+	}
+	return levels
+}
+
+// overviewLevel is the smallest level with a pixel for each of the tile's
+func overviewLevel(footprint image.Rectangle, levels int) int {
+	scale := float64(min(footprint.Dx(), footprint.Dy())) / float64(tileSize)
+	if !(scale >= 2) {
+		return 0
+	}
+	return min(levels, int(math.Floor(math.Log2(scale))))
+}
+
+// overviewScale maps the pixels of the image (src) to the overview (level),
+// which is a little bigger than a power of 2 smaller, as halving rounds up
+func overviewScale(pixelFromGeo func(LatLng) LatLng, src, level image.Rectangle) func(LatLng) LatLng {
+	sx := float64(level.Dx()) / float64(src.Dx())
+	sy := float64(level.Dy()) / float64(src.Dy())
+	return func(p LatLng) LatLng {
+		pxl := pixelFromGeo(p)
+		return LatLng{Lat: pxl.Lat * sy, Lng: pxl.Lng * sx}
+	}
+}
+
+// drawTile warps the part of src that the tile covers on to it or, zoomed
+// out, the part of the overview nearest to the tile's resolution
+func drawTile(dst *image.RGBA, src image.Image, overviews []image.Image, zoom, x, y int64, pixelFromGeo func(LatLng) LatLng, kernel Kernel) {
+	footprint := tileFootprint(zoom, x, y, pixelFromGeo)
+	if !footprint.Overlaps(src.Bounds()) {
+		return
+	}
+	if level := overviewLevel(footprint, len(overviews)); level > 0 {
+		overview := overviews[level-1]
+		pixelFromGeo = overviewScale(pixelFromGeo, src.Bounds(), overview.Bounds())
+		src = overview
+	}
+	warpTile(dst, src, zoom, x, y, pixelFromGeo, kernel)
+}
+
+func overviewFilename(dir string, level int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.png", level))
+}
+
+// readOverviews reads levels 1 to levels from dir, as long as they are all
+// there and were saved after the image was last changed (at modTime)
+func readOverviews(dir string, levels int, modTime time.Time) ([][]byte, error) {
+	var bufs [][]byte
+	for level := 1; level <= levels; level++ {
+		filename := overviewFilename(dir, level)
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		if info.ModTime().Before(modTime) {
+			return nil, fmt.Errorf("%v is older than the image", filename)
+		}
+		buf, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		bufs = append(bufs, buf)
+	}
+	return bufs, nil
+}
+
+func writeOverview(dir string, level int, buf []byte) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(overviewFilename(dir, level), buf, 0666)
+}
+
+// goOverviews reads the overviews of img from dir, or else makes and saves them
+func goOverviews(img image.Image, dir string, modTime time.Time) []image.Image {
+	levels := overviewLevels(img.Bounds().Dx(), img.Bounds().Dy())
+	if bufs, err := readOverviews(dir, levels, modTime); err == nil {
+		var overviews []image.Image
+		for _, buf := range bufs {
+			overview, err := png.Decode(bytes.NewReader(buf))
+			if err != nil {
+				break
+			}
+			overviews = append(overviews, overview)
+		}
+		if len(overviews) == levels {
+			return overviews
+		}
+	}
+
+	log.Printf("Building %v overviews in %v\n", levels, dir)
+	overviews := make([]image.Image, 0, levels)
+	src := img
+	for level := 1; level <= levels; level++ {
+		overview := halve(src)
+		overviews = append(overviews, overview)
+		src = overview
+
+		// Without them saved, they are just built again next time
+		w := bytes.Buffer{}
+		if err := png.Encode(&w, overview); err != nil {
+			log.Println("encode overview", err)
+		} else if err := writeOverview(dir, level, w.Bytes()); err != nil {
+			log.Println("save overview", err)
+		}
+	}
+	return overviews
+}
+
+// halve shrinks src to half its size (rounding up), averaging each 2x2
+// block of pixels
+func halve(src image.Image) *image.RGBA {
+	h := halfImage{src}
+	dst := image.NewRGBA(h.Bounds())
+	draw.Draw(dst, dst.Bounds(), h, image.ZP, draw.Src)
+	return dst
+}
+
+// halfImage is src halved (rounding up), averaging each 2x2 block of pixels,
+// worked out as it is read
+type halfImage struct {
+	src image.Image
+}
+
+func (h halfImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (h halfImage) Bounds() image.Rectangle {
+	b := h.src.Bounds()
+	return image.Rect(0, 0, (b.Dx()+1)/2, (b.Dy()+1)/2)
+}
+
+func (h halfImage) At(x, y int) color.Color {
+	bounds := h.src.Bounds()
+	var sum [4]uint32
+	n := uint32(0)
+	for _, d := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		sx, sy := bounds.Min.X+2*x+d[0], bounds.Min.Y+2*y+d[1]
+		if sx < bounds.Min.X || sy < bounds.Min.Y || sx >= bounds.Max.X || sy >= bounds.Max.Y {
+			continue
+		}
+		r, g, b, a := h.src.At(sx, sy).RGBA()
+		sum[0], sum[1], sum[2], sum[3] = sum[0]+r>>8, sum[1]+g>>8, sum[2]+b>>8, sum[3]+a>>8
+		n++
+	}
+	if n == 0 {
+		return color.RGBA{}
+	}
+	return color.RGBA{
+		R: uint8((sum[0] + n/2) / n),
+		G: uint8((sum[1] + n/2) / n),
+		B: uint8((sum[2] + n/2) / n),
+		A: uint8((sum[3] + n/2) / n),
+	}
+}
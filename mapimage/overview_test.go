@@ -0,0 +1,169 @@
+package mapimage
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOverviewLevels(t *testing.T) {
+	for _, c := range []struct{ width, height, expected int }{
+		{256, 100, 0},
+		{257, 100, 1},
+		{600, 400, 2},
+		{15000, 10000, 6},
+	} {
+		if result := overviewLevels(c.width, c.height); result != c.expected {
+			t.Errorf("incorrect for %vx%v, got: %v, want: %v.", c.width, c.height, result, c.expected)
+		}
+	}
+
+	for _, c := range []struct {
+		size, levels, expected int
+	}{
+		{256, 6, 0},
+		{511, 6, 0},
+		{512, 6, 1},
+		{4000, 6, 3},
+		{4000, 2, 2},
+	} {
+		if result := overviewLevel(image.Rect(0, 0, c.size, c.size), c.levels); result != c.expected {
+			t.Errorf("incorrect for %v pixels with %v levels, got: %v, want: %v.", c.size, c.levels, result, c.expected)
+		}
+	}
+}
+
+func TestOverviewScale(t *testing.T) {
+	// Odd sizes round up, so the overview isn't exactly a quarter the size
+	src := image.Rect(0, 0, 601, 401)
+	level := halfImage{halfImage{image.NewRGBA(src)}}.Bounds()
+	sut := overviewScale(func(p LatLng) LatLng { return p }, src, level)
+	for _, c := range []struct{ pixel, expected LatLng }{
+		{LatLng{}, LatLng{}},
+		{LatLng{Lat: 401, Lng: 601}, LatLng{Lat: 101, Lng: 151}},
+	} {
+		if result := sut(c.pixel); math.Abs(result.Lat-c.expected.Lat) > 1e-9 || math.Abs(result.Lng-c.expected.Lng) > 1e-9 {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", c.pixel, result, c.expected)
+		}
+	}
+}
+
+func TestHalve(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 3; x++ {
+			src.Set(x, y, color.NRGBA{R: uint8(30 * (x + 3*y)), A: 255})
+		}
+	}
+
+	sut := halve(src)
+	if sut.Bounds() != image.Rect(0, 0, 2, 2) {
+		t.Fatalf("incorrect size, got: %v.", sut.Bounds())
+	}
+	// Each pixel is the average of the (up to) 4 under it
+	for _, c := range []struct {
+		x, y     int
+		expected uint8
+	}{
+		{0, 0, (0 + 30 + 90 + 120) / 4},
+		{1, 0, (60 + 150) / 2},
+		{0, 1, (180 + 210) / 2},
+		{1, 1, 240},
+	} {
+		if result := sut.RGBAAt(c.x, c.y); result.R != c.expected || result.A != 255 {
+			t.Errorf("incorrect for %v,%v, got: %v, want: %v.", c.x, c.y, result, c.expected)
+		}
+	}
+}
+
+func TestGoOverviews(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overviews")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := checkerboard()
+	big := image.NewRGBA(image.Rect(0, 0, 1024, 600))
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 1024; x++ {
+			big.Set(x, y, src.At(x%16, y%16))
+		}
+	}
+
+	built := goOverviews(big, dir, time.Now().Add(-time.Hour))
+	if len(built) != 2 || built[1].Bounds() != image.Rect(0, 0, 256, 150) {
+		t.Fatalf("incorrect, got: %v levels.", len(built))
+	}
+	// The checkerboard averages out to grey
+	if c := color.RGBAModel.Convert(built[0].At(10, 10)).(color.RGBA); c.R < 127 || c.R > 128 {
+		t.Errorf("incorrect, got: %v.", c)
+	}
+
+	// Saved, and read back the next time
+	info, err := os.Stat(overviewFilename(dir, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := goOverviews(big, dir, time.Now().Add(-time.Hour))
+	if len(read) != 2 || read[1].Bounds() != built[1].Bounds() {
+		t.Errorf("incorrect, got: %v levels.", len(read))
+	}
+	if again, _ := os.Stat(overviewFilename(dir, 2)); !again.ModTime().Equal(info.ModTime()) {
+		t.Error("expected the saved overviews to be used")
+	}
+
+	// Once the image has changed, they are out of date
+	if _, err := readOverviews(dir, 2, time.Now().Add(time.Hour)); err == nil {
+		t.Error("expected the overviews to be out of date")
+	}
+}
+
+func TestOverviewTiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overviews")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	original := testMapImage(t, TileOptions{})
+	sut := testMapImage(t, TileOptions{OverviewDir: dir})
+	if len(sut.(*goImage).overviews) != 2 {
+		t.Fatalf("incorrect, got: %v overviews.", len(sut.(*goImage).overviews))
+	}
+
+	// Zoomed out, the tile from the overviews is much the same as from the
+	// image itself
+	zoom := int64(sut.MinZoom())
+	x, y := cornerTile(sut, zoom)
+	if level := overviewLevel(tileFootprint(zoom, x, y, sut.PixelFromGeo), 2); level == 0 {
+		t.Fatalf("incorrect, zoom %v isn't drawn from an overview.", zoom)
+	}
+	expected, err := png.Decode(original.MapTile(zoom, x, y, PNGTile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := png.Decode(sut.MapTile(zoom, x, y, PNGTile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total, n float64
+	for py := 0; py < int(tileSize); py++ {
+		for px := 0; px < int(tileSize); px++ {
+			r1, g1, _, a1 := expected.At(px, py).RGBA()
+			r2, g2, _, a2 := result.At(px, py).RGBA()
+			if a1 == 0xffff && a2 == 0xffff {
+				total += float64(int(r1>>8)-int(r2>>8)) + float64(int(g1>>8)-int(g2>>8))
+				n++
+			}
+		}
+	}
+	if n == 0 || total/n > 2 || total/n < -2 {
+		t.Errorf("incorrect, the mean difference is %v over %v pixels.", total/n, n)
+	}
+}
@@ -0,0 +1,229 @@
+package mapimage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// oziDatums are the names OziExplorer uses for the datums we know about
+var oziDatums = map[string]string{
+	"wgs 84":                 "WGS84",
+	"nad83":                  "NAD83",
+	"nad27 conus":            "NAD27",
+	"european 1950":          "ED50",
+	"ord srvy grt britn":     "OSGB36",
+	"potsdam rauenberg dhdn": "DHDN",
+	"tokyo":                  "Tokyo",
+	"arc 1960":               "Arc 1960",
+}
+
+// oziDatumPrefixes take the regional variants of NAD27 and ED50 as the average
+var oziDatumPrefixes = map[string]string{
+	"nad27":         "NAD27",
+	"european 1950": "ED50",
+}
+
+func oziDatum(name string) (string, error) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if d, ok := oziDatums[key]; ok {
+		return d, nil
+	}
+	for prefix, d := range oziDatumPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return d, nil
+		}
+	}
+	return "", fmt.Errorf("ozi: unsupported datum %q", name)
+}
+
+// ParseOziMap reads the calibration points, datum and projection of an
+// OziExplorer .map file
+func ParseOziMap(r io.Reader) (Calibration, error) {
+	var lines [][]string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimRight(scanner.Text(), "\r"), ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		lines = append(lines, fields)
+	}
+	if err := scanner.Err(); err != nil {
+		return Calibration{}, err
+	}
+	if len(lines) < 5 || !strings.HasPrefix(lines[0][0], "OziExplorer Map Data File") {
+		return Calibration{}, errors.New("ozi: not an OziExplorer .map file")
+	}
+
+	c := Calibration{
+		Title: strings.Join(lines[1], ","),
+		Image: strings.Join(lines[2], ","),
+	}
+	datum, err := oziDatum(lines[4][0])
+	if err != nil {
+		return Calibration{}, err
+	}
+
+	var projection string
+	var polyCal bool
+	var setup []string
+	type oziPoint struct {
+		pixel    LatLng
+		latLng   *LatLng
+		grid     *LatLng
+		zone     int
+		southern bool
+	}
+	var points []oziPoint
+	for _, fields := range lines[5:] {
+		switch {
+		case fields[0] == "Map Projection" && len(fields) > 1:
+			projection = fields[1]
+			polyCal = len(fields) > 3 && fields[2] == "PolyCal" && fields[3] == "Yes"
+
+		case fields[0] == "Projection Setup":
+			setup = fields[1:]
+
+		case strings.HasPrefix(fields[0], "Point") && len(fields) >= 17:
+			// Point01,xy,x,y,in,deg,lat deg,lat min,N,lng deg,lng min,E,grid,zone,easting,northing,N
+			if fields[2] == "" || fields[3] == "" {
+				continue
+			}
+			p := oziPoint{}
+			if p.pixel.Lng, err = strconv.ParseFloat(fields[2], 64); err != nil {
+				return Calibration{}, fmt.Errorf("ozi: %v: bad x %q", fields[0], fields[2])
+			}
+			if p.pixel.Lat, err = strconv.ParseFloat(fields[3], 64); err != nil {
+				return Calibration{}, fmt.Errorf("ozi: %v: bad y %q", fields[0], fields[3])
+			}
+
+			if fields[6] != "" && fields[9] != "" {
+				lat, err1 := oziDegrees(fields[6], fields[7], fields[8] == "S")
+				lng, err2 := oziDegrees(fields[9], fields[10], fields[11] == "W")
+				if err1 != nil || err2 != nil {
+					return Calibration{}, fmt.Errorf("ozi: %v: bad lat/lng", fields[0])
+				}
+				p.latLng = &LatLng{Lat: lat, Lng: lng}
+			} else if fields[14] != "" && fields[15] != "" {
+				easting, err1 := strconv.ParseFloat(fields[14], 64)
+				northing, err2 := strconv.ParseFloat(fields[15], 64)
+				if err1 != nil || err2 != nil {
+					return Calibration{}, fmt.Errorf("ozi: %v: bad grid reference", fields[0])
+				}
+				p.grid = &LatLng{Lat: northing, Lng: easting}
+				p.zone, _ = strconv.Atoi(strings.TrimRight(fields[13], "ABCDEFGHJKLMNPQRSTUVWXYZ"))
+				p.southern = fields[16] == "S"
+			} else {
+				continue
+			}
+			points = append(points, p)
+		}
+	}
+	if len(points) < 2 {
+		return Calibration{}, errors.New("ozi: needs at least 2 calibration points")
+	}
+
+	// Projection Setup,lat0,lon0,k,false easting,false northing,lat1,lat2,...
+	setupValue := func(i int) string {
+		if i < len(setup) && setup[i] != "" {
+			return setup[i]
+		}
+		return "0"
+	}
+	k := setupValue(2)
+	if f, err := strconv.ParseFloat(k, 64); err != nil || f == 0 {
+		k = "1"
+	}
+	datumParam := " +datum=" + strings.Replace(datum, " ", "", -1)
+	switch projection {
+	case "Latitude/Longitude":
+		c.CRS = "+proj=longlat" + datumParam
+	case "Mercator":
+		c.CRS = fmt.Sprintf("+proj=merc +lon_0=%v +k_0=%v +x_0=%v +y_0=%v",
+			setupValue(1), k, setupValue(3), setupValue(4)) + datumParam
+	case "Transverse Mercator":
+		c.CRS = fmt.Sprintf("+proj=tmerc +lat_0=%v +lon_0=%v +k_0=%v +x_0=%v +y_0=%v",
+			setupValue(0), setupValue(1), k, setupValue(3), setupValue(4)) + datumParam
+	case "Lambert Conformal Conic":
+		c.CRS = fmt.Sprintf("+proj=lcc +lat_0=%v +lon_0=%v +x_0=%v +y_0=%v +lat_1=%v +lat_2=%v",
+			setupValue(0), setupValue(1), setupValue(3), setupValue(4), setupValue(5), setupValue(6)) + datumParam
+	case "(UTM) Universal Transverse Mercator":
+		// The zone is only given with the grid references
+		zone, south := 0, false
+		for _, p := range points {
+			if p.grid != nil {
+				zone, south = p.zone, p.southern
+				break
+			}
+		}
+		// Without it, it is the zone of a point given as lat/lng
+		for _, p := range points {
+			if zone == 0 && p.latLng != nil {
+				zone = int(math.Floor((p.latLng.Lng+180)/6)) + 1
+				south = p.latLng.Lat < 0
+			}
+		}
+		if zone == 0 {
+			return Calibration{}, errors.New("ozi: UTM zone unknown")
+		}
+		c.CRS = fmt.Sprintf("+proj=utm +zone=%v", zone) + datumParam
+		if south {
+			c.CRS += " +south"
+		}
+	case "(BNG) British National Grid":
+		c.CRS = "EPSG:27700"
+	default:
+		return Calibration{}, fmt.Errorf("ozi: unsupported projection %q", projection)
+	}
+
+	crs, err := ParseCRS(c.CRS)
+	if err != nil {
+		return Calibration{}, err
+	}
+	for _, p := range points {
+		geo := p.latLng
+		if geo == nil {
+			geo = p.grid
+		} else if crs.projection != nil {
+			// The lat/lng is on the map's datum, which is what the
+			// projection works from
+			projected := LatLng(crs.projection.Project(geo.toPoint()))
+			geo = &projected
+		}
+		c.ReferencePoints = append(c.ReferencePoints, MapImagePair{Geographic: *geo, Pixel: p.pixel})
+	}
+
+	// OziExplorer fits a polynomial when asked to (and there are enough
+	// points), or an affine transformation from 3 points
+	switch n := len(c.ReferencePoints); {
+	case polyCal && n >= minimumPoints[Polynomial2Model]:
+		c.Transformation = Polynomial2Model
+	case n >= minimumPoints[AffineModel]:
+		c.Transformation = AffineModel
+	}
+	return c, nil
+}
+
+// oziDegrees is the degrees and minutes (negative in the south or west)
+func oziDegrees(degrees, minutes string, negative bool) (float64, error) {
+	d, err := strconv.ParseFloat(degrees, 64)
+	if err != nil {
+		return 0, err
+	}
+	m := 0.0
+	if minutes != "" {
+		if m, err = strconv.ParseFloat(minutes, 64); err != nil {
+			return 0, err
+		}
+	}
+	v := math.Abs(d) + m/60
+	if negative || d < 0 {
+		v = -v
+	}
+	return v, nil
+}
@@ -0,0 +1,121 @@
+package mapimage
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/floats"
+	"strings"
+	"testing"
+)
+
+// oziTestFile is a .map file, with the points (the rest of the 30 are empty)
+func oziTestFile(datum, projection string, points ...string) string {
+	lines := []string{
+		"OziExplorer Map Data File Version 2.2",
+		"Dardanelles",
+		`C:\Maps\Orographical_map_of_the_Dardanelles.jpg`,
+		"1 ,Map Code,",
+		datum + ",WGS 84,   0.0000,   0.0000,WGS 84",
+		"Reserved 1",
+		"Reserved 2",
+		"Magnetic Variation,,,E",
+		"Map Projection," + projection + ",PolyCal,No,AutoCalOnly,No,BSBUseWPX,No",
+	}
+	for i := 0; i < 30; i++ {
+		if i < len(points) {
+			lines = append(lines, fmt.Sprintf("Point%02d,xy,%v", i+1, points[i]))
+		} else {
+			lines = append(lines, fmt.Sprintf("Point%02d,xy,     ,     ,in, deg,    ,        ,N,    ,        ,E, grid,   ,           ,           ,N", i+1))
+		}
+	}
+	lines = append(lines,
+		"Projection Setup,     0.000000000,     0.000000000,     0.000000000,            0.00,            0.00,,,,,",
+		"Map Feature = MF ; Map Comment = MC     These follow if they exist",
+		"MM0,Yes",
+		"IWH,Map Image Width/Height,10507,15328",
+	)
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func TestParseOziMapLatLng(t *testing.T) {
+	sut, err := ParseOziMap(strings.NewReader(oziTestFile("WGS 84", "Latitude/Longitude",
+		" 2531, 1756,in, deg,  40, 25.0000,N,  26, 15.0000,E, grid,   ,           ,           ,N",
+		" 7128,13140,in, deg,  40,  0.0000,N,  26, 30.0000,E, grid,   ,           ,           ,N",
+		" 1881, 4468,in, deg,  40, 18.9696,N,  26, 12.9171,E, grid,   ,           ,           ,N",
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sut.Title != "Dardanelles" || sut.Image != `C:\Maps\Orographical_map_of_the_Dardanelles.jpg` {
+		t.Errorf("incorrect, got: %q %q.", sut.Title, sut.Image)
+	}
+	if sut.CRS != "+proj=longlat +datum=WGS84" || sut.Transformation != AffineModel {
+		t.Errorf("incorrect, got: %q %q.", sut.CRS, sut.Transformation)
+	}
+
+	expected := []MapImagePair{
+		{Geographic: LatLng{Lat: 40 + 25.0/60, Lng: 26.25}, Pixel: LatLng{Lat: 1756, Lng: 2531}},
+		{Geographic: LatLng{Lat: 40, Lng: 26.5}, Pixel: LatLng{Lat: 13140, Lng: 7128}},
+		{Geographic: LatLng{Lat: 40 + 18.9696/60, Lng: 26 + 12.9171/60}, Pixel: LatLng{Lat: 4468, Lng: 1881}},
+	}
+	if len(sut.ReferencePoints) != len(expected) {
+		t.Fatalf("incorrect, got: %v, want: %v.", sut.ReferencePoints, expected)
+	}
+	for i, rp := range sut.ReferencePoints {
+		if !rp.Geographic.toPoint().IsCloseTo(expected[i].Geographic.toPoint()) || rp.Pixel != expected[i].Pixel {
+			t.Errorf("incorrect for point %v, got: %v, want: %v.", i, rp, expected[i])
+		}
+	}
+
+	crs, err := ParseCRS(sut.CRS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGeoreference(sut.Transformation, crs, sut.ReferencePoints, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseOziMapUTM(t *testing.T) {
+	sut, err := ParseOziMap(strings.NewReader(oziTestFile("European 1950 (Mean Values)", "(UTM) Universal Transverse Mercator",
+		" 1000, 1000,in, deg,    ,        ,N,    ,        ,E, grid, 35T,   453120,  4455800,N",
+		" 9000, 1200,in, deg,    ,        ,N,    ,        ,E, grid, 35T,   469120,  4455400,N",
+		" 5000, 9000,in, deg,  40, 10.0000,N,  26, 20.0000,E, grid,   ,           ,           ,N",
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sut.CRS != "+proj=utm +zone=35 +datum=ED50" {
+		t.Errorf("incorrect CRS, got: %q.", sut.CRS)
+	}
+
+	// The lat/lng point is converted to the grid (on the map's datum)
+	utm := NewUTMTransformationOn(International1924, 35, false)
+	expected := utm.Project(PointNorthingEasting(40+10.0/60, 26+20.0/60))
+	result := sut.ReferencePoints[2].Geographic
+	if !floats.EqualWithinAbs(result.Lng, expected.Lng, 1e-6) || !floats.EqualWithinAbs(result.Lat, expected.Lat, 1e-6) {
+		t.Errorf("incorrect, got: %v, want: %v.", result, expected)
+	}
+	if sut.ReferencePoints[0].Geographic != (LatLng{Lat: 4455800, Lng: 453120}) {
+		t.Errorf("incorrect, got: %v.", sut.ReferencePoints[0].Geographic)
+	}
+}
+
+func TestBadOziMaps(t *testing.T) {
+	point := " 2531, 1756,in, deg,  40, 25.0000,N,  26, 15.0000,E, grid,   ,           ,           ,N"
+	for name, content := range map[string]string{
+		"not a .map": "Title\r\nImage\r\n",
+		"datum":      oziTestFile("Gallipoli 1915", "Latitude/Longitude", point, point),
+		"projection": oziTestFile("WGS 84", "Polyconic (American)", point, point),
+		"one point":  oziTestFile("WGS 84", "Latitude/Longitude", point),
+		"bad pixel":  oziTestFile("WGS 84", "Latitude/Longitude", point, " x, 1756,in, deg,  40, 25.0000,N,  26, 15.0000,E, grid,   ,           ,           ,N"),
+		"no UTM zone": oziTestFile("WGS 84", "(UTM) Universal Transverse Mercator",
+			" 2531, 1756,in, deg,    ,        ,N,    ,        ,E, grid,   ,   453120,  4455800,N",
+			" 1, 17,in, deg,    ,        ,N,    ,        ,E, grid,   ,   463120,  4465800,N"),
+		"bad lat/lng": oziTestFile("WGS 84", "Latitude/Longitude", point, " 1, 1756,in, deg,  40, 2x.0000,N,  26, 15.0000,E, grid,   ,           ,           ,N"),
+	} {
+		if _, err := ParseOziMap(strings.NewReader(content)); err == nil {
+			t.Errorf("expected an error for %v", name)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package mapimage
+
+import (
+	"errors"
+	"math"
+)
+
+// PiecewiseAffineTransformation is an affine for each triangle of the points,
+// and one fitted to all of them outside of the triangles
+type PiecewiseAffineTransformation struct {
+	standardPoints []Point
+	localPoints    []Point
+	triangles      []triangle
+	affines        []AffineTransformation
+	global         AffineTransformation
+}
+
+// Needs at least 3 points that are not all in a line
+func NewPiecewiseAffineTransformationFromPoints(standardPoints []Point, localPoints []Point) (PiecewiseAffineTransformation, error) {
+	if len(standardPoints) != len(localPoints) {
+		return PiecewiseAffineTransformation{}, errors.New("piecewise-affine: mismatched number of points")
+	}
+
+	triangles := delaunay(localPoints)
+	if len(triangles) == 0 {
+		return PiecewiseAffineTransformation{}, errors.New("piecewise-affine: at least 3 points, not in a line, are required")
+	}
+
+	global, err := NewAffineTransformationFromPoints(standardPoints, localPoints)
+	if err != nil {
+		return PiecewiseAffineTransformation{}, err
+	}
+
+	t := PiecewiseAffineTransformation{
+		standardPoints: standardPoints,
+		localPoints:    localPoints,
+		triangles:      triangles,
+		global:         global,
+	}
+	for _, tri := range triangles {
+		standard := []Point{standardPoints[tri[0]], standardPoints[tri[1]], standardPoints[tri[2]]}
+		local := []Point{localPoints[tri[0]], localPoints[tri[1]], localPoints[tri[2]]}
+		affine, err := NewAffineTransformationFromPoints(standard, local)
+		if err != nil {
+			return PiecewiseAffineTransformation{}, err
+		}
+		t.affines = append(t.affines, affine)
+	}
+
+	return t, nil
+}
+
+// Inverse uses the same triangles, so that it exactly undoes t (unlike
+// triangulating the standard points afresh, which could pick other triangles)
+func (t *PiecewiseAffineTransformation) Inverse() Transformation {
+	inverse := PiecewiseAffineTransformation{
+		standardPoints: t.localPoints,
+		localPoints:    t.standardPoints,
+		triangles:      t.triangles,
+		global:         *t.global.Inverse().(*AffineTransformation),
+	}
+	for _, affine := range t.affines {
+		inverse.affines = append(inverse.affines, *affine.Inverse().(*AffineTransformation))
+	}
+	return &inverse
+}
+
+// locate finds the triangle that p is in, or -1 if it is outside them all
+func (t *PiecewiseAffineTransformation) locate(p Point) int {
+	const tol = 1e-9
+	for i, tri := range t.triangles {
+		a, b, c := t.localPoints[tri[0]], t.localPoints[tri[1]], t.localPoints[tri[2]]
+		minX, maxX := math.Min(a.Lng, math.Min(b.Lng, c.Lng)), math.Max(a.Lng, math.Max(b.Lng, c.Lng))
+		minY, maxY := math.Min(a.Lat, math.Min(b.Lat, c.Lat)), math.Max(a.Lat, math.Max(b.Lat, c.Lat))
+		// (with a little slack, so that corners that have been projected there
+		// and back again, and so are not quite exact, are still found)
+		slack := 1e-9 * (maxX - minX + maxY - minY)
+		if p.Lng < minX-slack || p.Lng > maxX+slack || p.Lat < minY-slack || p.Lat > maxY+slack {
+			continue
+		}
+		wa, wb, wc := barycentric(a, b, c, p)
+		if wa >= -tol && wb >= -tol && wc >= -tol {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *PiecewiseAffineTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *PiecewiseAffineTransformation) Projects(points ...Point) (results []Point) {
+	for _, p := range points {
+		if i := t.locate(p); i >= 0 {
+			results = append(results, t.affines[i].Project(p))
+		} else {
+			results = append(results, t.global.Project(p))
+		}
+	}
+	return
+}
@@ -0,0 +1,81 @@
+package mapimage
+
+import (
+	"gonum.org/v1/gonum/floats"
+	"testing"
+)
+
+func TestDelaunayEmptyCircumcircles(t *testing.T) {
+	triangles := delaunay(tpsTestPixels)
+	// For points in general position: 2n - 2 - (points on the hull)
+	if len(triangles) != 2*len(tpsTestPixels)-2-5 {
+		t.Errorf("incorrect number of triangles, got: %v, want: %v.", len(triangles), 2*len(tpsTestPixels)-2-5)
+	}
+
+	for _, tri := range triangles {
+		a, b, c := tpsTestPixels[tri[0]], tpsTestPixels[tri[1]], tpsTestPixels[tri[2]]
+		for i, p := range tpsTestPixels {
+			if i == tri[0] || i == tri[1] || i == tri[2] {
+				continue
+			}
+			if inCircumcircle(a, b, c, p) {
+				t.Errorf("%v is inside the circumcircle of %v", p, tri)
+			}
+		}
+	}
+}
+
+func TestDelaunayCollinear(t *testing.T) {
+	if triangles := delaunay([]Point{PointXY(0, 0), PointXY(1, 1), PointXY(2, 2)}); len(triangles) != 0 {
+		t.Errorf("expected no triangles, got: %v", triangles)
+	}
+}
+
+func TestPiecewiseAffinePassesThroughControlPoints(t *testing.T) {
+	sut, err := NewPiecewiseAffineTransformationFromPoints(tpsTestGeo, tpsTestPixels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inverse := sut.Inverse()
+
+	for i, result := range sut.Projects(tpsTestPixels...) {
+		if !result.IsCloseTo(tpsTestGeo[i]) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", tpsTestPixels[i], result, tpsTestGeo[i])
+		}
+	}
+
+	for _, pt := range []Point{PointXY(2500, 2500), PointXY(7777, 8888), PointXY(5000, 5000)} {
+		result := inverse.Project(sut.Project(pt))
+		if !floats.EqualWithinAbs(result.Lat, pt.Lat, 1e-6) ||
+			!floats.EqualWithinAbs(result.Lng, pt.Lng, 1e-6) {
+			t.Errorf("round trip incorrect for %v, got: %v.", pt, result)
+		}
+	}
+}
+
+func TestPiecewiseAffineIsLocal(t *testing.T) {
+	before, err := NewPiecewiseAffineTransformationFromPoints(tpsTestGeo, tpsTestPixels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new (and quite wrong) point in the bottom right corner...
+	after, err := NewPiecewiseAffineTransformationFromPoints(
+		append(append([]Point{}, tpsTestGeo...), PointEastingNorthing(26.31, 40.19)),
+		append(append([]Point{}, tpsTestPixels...), PointXY(8000, 8000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ...does not move the top left
+	pt := PointXY(1000, 1000)
+	if a, b := before.Project(pt), after.Project(pt); !a.IsCloseTo(b) {
+		t.Errorf("moved from %v to %v", a, b)
+	}
+
+	// ...but does move the bottom right
+	pt = PointXY(8000, 8000)
+	if a, b := before.Project(pt), after.Project(pt); a.IsCloseTo(b) {
+		t.Errorf("did not move from %v", a)
+	}
+}
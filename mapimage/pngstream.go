@@ -0,0 +1,395 @@
+package mapimage
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+// pngStream is a (non-interlaced) PNG decoded a band of rows at a time, from
+// the top down, for a chunk store
+type pngStream struct {
+	width, height    int
+	colorType, depth int
+	// The palette, with the alpha from the tRNS chunk
+	palette []color.NRGBA
+	// The colour (as samples) that is transparent, for grey and RGB images
+	key []int
+
+	z    io.Reader
+	idat *idatReader
+	// Bytes per pixel (as the filters count them, so at least 1) and per row
+	bpp, stride int
+	cur, prev   []byte
+
+	// The rows decoded so far that are in the band, and the next to read
+	band *image.RGBA
+	next int
+	err  error
+}
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// The PNG colour types
+const (
+	pngGrey      = 0
+	pngRGB       = 2
+	pngPalette   = 3
+	pngGreyAlpha = 4
+	pngRGBA      = 6
+)
+
+// newPNGStream reads the PNG's header, up to where its pixels start
+func newPNGStream(r io.Reader) (*pngStream, error) {
+	br := bufio.NewReader(r)
+	var signature [8]byte
+	if _, err := io.ReadFull(br, signature[:]); err != nil {
+		return nil, err
+	}
+	if string(signature[:]) != pngSignature {
+		return nil, errors.New("png: not a PNG")
+	}
+
+	s := pngStream{band: &image.RGBA{}}
+	for {
+		length, kind, err := readPNGChunkHeader(br)
+		if err != nil {
+			return nil, err
+		}
+		if kind == "IDAT" {
+			if s.width == 0 {
+				return nil, errors.New("png: no IHDR")
+			}
+			s.idat = newIDATReader(br, length)
+			s.z, err = zlib.NewReader(s.idat)
+			if err != nil {
+				return nil, err
+			}
+			return &s, nil
+		}
+
+		data := make([]byte, length+4)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		crc := crc32.Update(crc32.ChecksumIEEE([]byte(kind)), crc32.IEEETable, data[:length])
+		if binary.BigEndian.Uint32(data[length:]) != crc {
+			return nil, fmt.Errorf("png: bad CRC in %v", kind)
+		}
+		data = data[:length]
+		switch kind {
+		case "IHDR":
+			if err := s.parseIHDR(data); err != nil {
+				return nil, err
+			}
+		case "PLTE":
+			for i := 0; i+2 < len(data); i += 3 {
+				s.palette = append(s.palette, color.NRGBA{R: data[i], G: data[i+1], B: data[i+2], A: 255})
+			}
+		case "tRNS":
+			switch s.colorType {
+			case pngPalette:
+				for i := 0; i < len(data) && i < len(s.palette); i++ {
+					s.palette[i].A = data[i]
+				}
+			case pngGrey, pngRGB:
+				for i := 0; i+1 < len(data); i += 2 {
+					s.key = append(s.key, int(binary.BigEndian.Uint16(data[i:])))
+				}
+			}
+		case "IEND":
+			return nil, errors.New("png: no IDAT")
+		}
+	}
+}
+
+func readPNGChunkHeader(r io.Reader) (uint32, string, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, "", err
+	}
+	return binary.BigEndian.Uint32(header[:4]), string(header[4:]), nil
+}
+
+func (s *pngStream) parseIHDR(data []byte) error {
+	if len(data) != 13 {
+		return errors.New("png: bad IHDR")
+	}
+	s.width = int(binary.BigEndian.Uint32(data[0:]))
+	s.height = int(binary.BigEndian.Uint32(data[4:]))
+	s.depth, s.colorType = int(data[8]), int(data[9])
+	if data[12] != 0 {
+		return errors.New("png: interlaced")
+	}
+	if s.width <= 0 || s.height <= 0 {
+		return errors.New("png: empty image")
+	}
+
+	samples := map[int]int{pngGrey: 1, pngRGB: 3, pngPalette: 1, pngGreyAlpha: 2, pngRGBA: 4}[s.colorType]
+	valid := false
+	switch s.colorType {
+	case pngGrey:
+		valid = s.depth == 1 || s.depth == 2 || s.depth == 4 || s.depth == 8 || s.depth == 16
+	case pngPalette:
+		valid = s.depth == 1 || s.depth == 2 || s.depth == 4 || s.depth == 8
+	case pngRGB, pngGreyAlpha, pngRGBA:
+		valid = s.depth == 8 || s.depth == 16
+	}
+	if !valid {
+		return fmt.Errorf("png: unsupported colour type %v, depth %v", s.colorType, s.depth)
+	}
+	s.bpp = max(1, samples*s.depth/8)
+	s.stride = (s.width*samples*s.depth + 7) / 8
+	s.cur, s.prev = make([]byte, 1+s.stride), make([]byte, 1+s.stride)
+	return nil
+}
+
+// idatReader reads the data of the IDAT chunks, one after the other,
+// checking the CRC at the end of each
+type idatReader struct {
+	r         *bufio.Reader
+	remaining uint32
+	// The CRC of the chunk so far, or nil once it has been checked
+	crc  hash.Hash32
+	done bool
+	// A bad CRC, which the zlib reader's buffering can leave unseen
+	err error
+}
+
+func newIDATReader(r *bufio.Reader, length uint32) *idatReader {
+	d := idatReader{r: r, remaining: length, crc: crc32.NewIEEE()}
+	d.crc.Write([]byte("IDAT"))
+	return &d
+}
+
+// checkCRC reads the CRC at the end of the chunk
+func (d *idatReader) checkCRC() error {
+	var b [4]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(b[:]) != d.crc.Sum32() {
+		d.err = errors.New("png: bad CRC in IDAT")
+		return d.err
+	}
+	d.crc = nil
+	return nil
+}
+
+func (d *idatReader) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	for d.remaining == 0 {
+		// (An empty IDAT)
+		if d.crc != nil {
+			if err := d.checkCRC(); err != nil {
+				return 0, err
+			}
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		// The next chunk is only more pixels if it is an IDAT too
+		length, kind, err := readPNGChunkHeader(d.r)
+		if err != nil {
+			return 0, err
+		}
+		if kind != "IDAT" {
+			d.done = true
+			return 0, io.EOF
+		}
+		d.remaining, d.crc = length, crc32.NewIEEE()
+		d.crc.Write([]byte(kind))
+	}
+	if uint32(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n, err := d.r.Read(p)
+	d.crc.Write(p[:n])
+	d.remaining -= uint32(n)
+	if d.remaining == 0 && err == nil {
+		err = d.checkCRC()
+	}
+	return n, err
+}
+
+func (s *pngStream) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (s *pngStream) Bounds() image.Rectangle {
+	return image.Rect(0, 0, s.width, s.height)
+}
+
+func (s *pngStream) At(x, y int) color.Color {
+	if s.err != nil || !image.Pt(x, y).In(s.Bounds()) {
+		return color.RGBA{}
+	}
+	if y < s.band.Rect.Min.Y {
+		s.err = fmt.Errorf("png: can't go back up to row %v", y)
+		return color.RGBA{}
+	}
+	if y >= s.band.Rect.Max.Y {
+		if s.err = s.readBand(y - y%chunkSize); s.err != nil {
+			return color.RGBA{}
+		}
+	}
+	return s.band.RGBAAt(x, y)
+}
+
+// Err is the error (if any) from reading the pixels
+func (s *pngStream) Err() error {
+	return s.err
+}
+
+// readBand decodes the rows from top, for a chunk down, skipping any before
+func (s *pngStream) readBand(top int) error {
+	if s.band.Pix == nil {
+		s.band.Pix = make([]uint8, 4*s.width*chunkSize)
+		s.band.Stride = 4 * s.width
+	}
+	s.band.Rect = image.Rect(0, top, s.width, min(top+chunkSize, s.height))
+	for ; s.next < s.band.Rect.Max.Y; s.next++ {
+		if err := s.readRow(); err != nil {
+			return fmt.Errorf("png: row %v: %v", s.next, err)
+		}
+		if s.next >= top {
+			for x := 0; x < s.width; x++ {
+				s.band.SetRGBA(x, s.next, color.RGBAModel.Convert(s.pixel(x)).(color.RGBA))
+			}
+		}
+	}
+	if s.next == s.height {
+		// To the end of the pixels, so their checksums are checked too
+		if _, err := io.Copy(ioutil.Discard, s.z); err != nil {
+			return err
+		}
+		if s.idat.err != nil {
+			return s.idat.err
+		}
+	}
+	return nil
+}
+
+// readRow reads the next row into cur, undoing its filter
+func (s *pngStream) readRow() error {
+	s.cur, s.prev = s.prev, s.cur
+	if _, err := io.ReadFull(s.z, s.cur); err != nil {
+		return err
+	}
+	cur, prev := s.cur[1:], s.prev[1:]
+	switch s.cur[0] {
+	case 0:
+	case 1:
+		for i := s.bpp; i < len(cur); i++ {
+			cur[i] += cur[i-s.bpp]
+		}
+	case 2:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case 3:
+		for i := range cur {
+			left := 0
+			if i >= s.bpp {
+				left = int(cur[i-s.bpp])
+			}
+			cur[i] += uint8((left + int(prev[i])) / 2)
+		}
+	case 4:
+		for i := range cur {
+			a, c := 0, 0
+			if i >= s.bpp {
+				a, c = int(cur[i-s.bpp]), int(prev[i-s.bpp])
+			}
+			cur[i] += paeth(a, int(prev[i]), c)
+		}
+	default:
+		return fmt.Errorf("bad filter %v", s.cur[0])
+	}
+	return nil
+}
+
+func paeth(a, b, c int) uint8 {
+	abs := func(v int) int {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	if pa <= pb && pa <= pc {
+		return uint8(a)
+	} else if pb <= pc {
+		return uint8(b)
+	}
+	return uint8(c)
+}
+
+// sample is the i'th sample of the current row, at its own depth
+func (s *pngStream) sample(i int) int {
+	row := s.cur[1:]
+	switch s.depth {
+	case 8:
+		return int(row[i])
+	case 16:
+		return int(binary.BigEndian.Uint16(row[2*i:]))
+	}
+	bit := i * s.depth
+	return int(row[bit/8]>>uint(8-s.depth-bit%8)) & (1<<uint(s.depth) - 1)
+}
+
+// pixel is the colour of the current row's pixel
+func (s *pngStream) pixel(x int) color.Color {
+	// The sample scaled to 16 bits
+	scaled := func(v int) uint16 {
+		switch {
+		case s.depth == 16:
+			return uint16(v)
+		case s.depth < 8:
+			return uint16(v * 255 / (1<<uint(s.depth) - 1) * 0x101)
+		}
+		return uint16(v * 0x101)
+	}
+
+	switch s.colorType {
+	case pngGrey:
+		v := s.sample(x)
+		c := color.NRGBA64{R: scaled(v), G: scaled(v), B: scaled(v), A: 0xffff}
+		if len(s.key) >= 1 && v == s.key[0] {
+			c.A = 0
+		}
+		return c
+	case pngRGB:
+		r, g, b := s.sample(3*x), s.sample(3*x+1), s.sample(3*x+2)
+		c := color.NRGBA64{R: scaled(r), G: scaled(g), B: scaled(b), A: 0xffff}
+		if len(s.key) >= 3 && r == s.key[0] && g == s.key[1] && b == s.key[2] {
+			c.A = 0
+		}
+		return c
+	case pngPalette:
+		if i := s.sample(x); i < len(s.palette) {
+			return s.palette[i]
+		}
+		return color.NRGBA{}
+	case pngGreyAlpha:
+		v := scaled(s.sample(2 * x))
+		return color.NRGBA64{R: v, G: v, B: v, A: scaled(s.sample(2*x + 1))}
+	}
+	return color.NRGBA64{
+		R: scaled(s.sample(4 * x)),
+		G: scaled(s.sample(4*x + 1)),
+		B: scaled(s.sample(4*x + 2)),
+		A: scaled(s.sample(4*x + 3)),
+	}
+}
@@ -0,0 +1,261 @@
+package mapimage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+func TestPNGStream(t *testing.T) {
+	// Taller than a chunk, with the encoder picking a mix of filters
+	bounds := image.Rect(0, 0, 70, 300)
+	nrgba := image.NewNRGBA(bounds)
+	rgb := image.NewRGBA(bounds)
+	grey := image.NewGray(bounds)
+	grey16 := image.NewGray16(bounds)
+	rgba64 := image.NewNRGBA64(bounds)
+	palette := image.NewPaletted(bounds, color.Palette{color.Transparent, color.NRGBA{R: 255, A: 128}, color.White})
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			nrgba.Set(x, y, color.NRGBA{R: uint8(x * 3), G: uint8(y), B: uint8(x * y), A: uint8(x + y)})
+			rgb.Set(x, y, color.RGBA{R: uint8(y), G: uint8(x * 7), B: uint8(x ^ y), A: 255})
+			grey.Set(x, y, color.Gray{Y: uint8(x*y + y)})
+			grey16.Set(x, y, color.Gray16{Y: uint16(x * y * 97)})
+			rgba64.Set(x, y, color.NRGBA64{R: uint16(x * 900), G: uint16(y * 200), B: 7, A: uint16(60000 - x*y)})
+			palette.SetColorIndex(x, y, uint8((x+y)%3))
+		}
+	}
+
+	for name, img := range map[string]image.Image{
+		"nrgba": nrgba, "rgb": rgb, "grey": grey, "grey16": grey16, "nrgba64": rgba64, "palette": palette,
+	} {
+		w := bytes.Buffer{}
+		if err := png.Encode(&w, img); err != nil {
+			t.Fatal(err)
+		}
+		expected, err := png.Decode(bytes.NewReader(w.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sut, err := newPNGStream(bytes.NewReader(w.Bytes()))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if sut.Bounds() != bounds {
+			t.Errorf("%v: incorrect bounds, got: %v.", name, sut.Bounds())
+		}
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				want := color.RGBAModel.Convert(expected.At(x, y))
+				if result := sut.At(x, y); result != want {
+					t.Fatalf("%v: incorrect for %v,%v, got: %v, want: %v.", name, x, y, result, want)
+				}
+			}
+		}
+		if sut.Err() != nil {
+			t.Errorf("%v: %v", name, sut.Err())
+		}
+
+		// It only goes down the image
+		sut.At(0, 0)
+		if sut.Err() == nil {
+			t.Errorf("%v: expected an error going back up", name)
+		}
+	}
+
+	if _, err := newPNGStream(bytes.NewReader([]byte("GIF89a"))); err == nil {
+		t.Error("expected an error for a GIF")
+	}
+}
+
+// pngChunk is a chunk of a PNG, with its length and CRC
+func pngChunk(kind string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// testPNG encodes the rows (of samples already packed at the depth), each
+// with the next of the filters, with the pixels split into IDATs of idatSize
+func testPNG(width, colorType, depth int, plte, trns []byte, rows [][]byte, idatSize int) []byte {
+	samples := map[int]int{pngGrey: 1, pngRGB: 3, pngPalette: 1, pngGreyAlpha: 2, pngRGBA: 4}[colorType]
+	bpp := max(1, samples*depth/8)
+
+	var raw bytes.Buffer
+	prev := make([]byte, len(rows[0]))
+	for y, row := range rows {
+		filter := byte(y % 5)
+		raw.WriteByte(filter)
+		for i := range row {
+			a, b, c := 0, int(prev[i]), 0
+			if i >= bpp {
+				a, c = int(row[i-bpp]), int(prev[i-bpp])
+			}
+			switch filter {
+			case 1:
+				raw.WriteByte(row[i] - byte(a))
+			case 2:
+				raw.WriteByte(row[i] - byte(b))
+			case 3:
+				raw.WriteByte(row[i] - byte((a+b)/2))
+			case 4:
+				raw.WriteByte(row[i] - paeth(a, b, c))
+			default:
+				raw.WriteByte(row[i])
+			}
+		}
+		prev = row
+	}
+	var compressed bytes.Buffer
+	z := zlib.NewWriter(&compressed)
+	z.Write(raw.Bytes())
+	z.Close()
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr, uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(len(rows)))
+	ihdr[8], ihdr[9] = byte(depth), byte(colorType)
+	file := append([]byte(pngSignature), pngChunk("IHDR", ihdr)...)
+	if plte != nil {
+		file = append(file, pngChunk("PLTE", plte)...)
+	}
+	if trns != nil {
+		file = append(file, pngChunk("tRNS", trns)...)
+	}
+	for data := compressed.Bytes(); len(data) > 0; data = data[min(idatSize, len(data)):] {
+		file = append(file, pngChunk("IDAT", data[:min(idatSize, len(data))])...)
+	}
+	return append(file, pngChunk("IEND", nil)...)
+}
+
+func TestPNGStreamFormats(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	// An odd width, so the rows of under 8 bits end part way through a byte
+	width, height := 37, chunkSize+20
+	for _, c := range []struct {
+		colorType, depth int
+		trns             bool
+	}{
+		{pngGrey, 1, false}, {pngGrey, 2, false}, {pngGrey, 4, false}, {pngGrey, 8, false}, {pngGrey, 16, false},
+		{pngGrey, 2, true}, {pngGrey, 8, true}, {pngGrey, 16, true},
+		{pngRGB, 8, false}, {pngRGB, 16, false}, {pngRGB, 8, true}, {pngRGB, 16, true},
+		{pngPalette, 1, false}, {pngPalette, 2, false}, {pngPalette, 4, false}, {pngPalette, 8, false},
+		{pngPalette, 4, true}, {pngPalette, 8, true},
+		{pngGreyAlpha, 8, false}, {pngGreyAlpha, 16, false},
+		{pngRGBA, 8, false}, {pngRGBA, 16, false},
+	} {
+		samples := map[int]int{pngGrey: 1, pngRGB: 3, pngPalette: 1, pngGreyAlpha: 2, pngRGBA: 4}[c.colorType]
+		rows := make([][]byte, height)
+		for y := range rows {
+			rows[y] = make([]byte, (width*samples*c.depth+7)/8)
+			rnd.Read(rows[y])
+		}
+		// The colour of the first pixel is the transparent one
+		var plte, trns []byte
+		switch {
+		case c.colorType == pngPalette:
+			plte = make([]byte, 3<<uint(c.depth))
+			rnd.Read(plte)
+			if c.trns {
+				trns = []byte{0, 100, 200}
+			}
+		case c.trns && c.depth == 16:
+			trns = append([]byte{}, rows[0][:2*samples]...)
+		case c.trns && c.depth == 8:
+			for _, v := range rows[0][:samples] {
+				trns = append(trns, 0, v)
+			}
+		case c.trns:
+			trns = []byte{0, rows[0][0] >> uint(8-c.depth)}
+		}
+
+		for _, idatSize := range []int{1 << 20, 1000, 1} {
+			file := testPNG(width, c.colorType, c.depth, plte, trns, rows, idatSize)
+			name := fmt.Sprintf("colour type %v, depth %v, tRNS %v, IDATs of %v", c.colorType, c.depth, c.trns, idatSize)
+			expected, err := png.Decode(bytes.NewReader(file))
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			sut, err := newPNGStream(bytes.NewReader(file))
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+		pixels:
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					want := color.RGBAModel.Convert(expected.At(x, y))
+					if result := sut.At(x, y); result != want {
+						t.Errorf("incorrect for %v at %v,%v, got: %v, want: %v.", name, x, y, result, want)
+						break pixels
+					}
+				}
+			}
+			if sut.Err() != nil {
+				t.Errorf("%v: %v", name, sut.Err())
+			}
+		}
+	}
+}
+
+func TestPNGStreamCRC(t *testing.T) {
+	rows := [][]byte{make([]byte, 4*chunkSize), make([]byte, 4*chunkSize)}
+	file := testPNG(chunkSize, pngRGBA, 8, nil, nil, rows, 10)
+	// The first IDAT is the chunk after the IHDR
+	idat := len(pngSignature) + 12 + 13
+
+	for _, c := range []struct {
+		name   string
+		offset int
+	}{
+		{"IHDR", len(pngSignature) + 8 + 13},
+		{"first IDAT", idat + 8 + 10},
+		{"last IDAT", len(file) - 12 - 1},
+	} {
+		bad := append([]byte{}, file...)
+		bad[c.offset] ^= 0xff
+		sut, err := newPNGStream(bytes.NewReader(bad))
+		if err == nil {
+			sut.At(0, 0)
+			err = sut.Err()
+		}
+		if err == nil {
+			t.Errorf("expected an error for a bad CRC in the %v", c.name)
+		}
+	}
+}
+
+func TestDecodeForChunks(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 80))
+	w := bytes.Buffer{}
+	if err := jpeg.Encode(&w, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decodeForChunks(bytes.NewReader(w.Bytes())); err != nil {
+		t.Error(err)
+	}
+	defer func(limit int64) { MaxDecodeMemory = limit }(MaxDecodeMemory)
+	MaxDecodeMemory = 4 * 100 * 79
+	if _, err := decodeForChunks(bytes.NewReader(w.Bytes())); err == nil {
+		t.Error("expected an error for a JPEG over the limit")
+	}
+
+	// A PNG is read a band at a time, so there's no limit
+	w.Reset()
+	png.Encode(&w, img)
+	if result, err := decodeForChunks(bytes.NewReader(w.Bytes())); err != nil {
+		t.Error(err)
+	} else if _, ok := result.(*pngStream); !ok {
+		t.Errorf("incorrect, got: %T.", result)
+	}
+}
@@ -0,0 +1,150 @@
+package mapimage
+
+import (
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/mat"
+	"math"
+)
+
+// PolynomialTransformation is a polynomial of order 2 or 3 in the
+// (normalised) x and y, e.g. e = a0 + a1*x + a2*y + a3*x^2 + a4*x*y + a5*y^2
+type PolynomialTransformation struct {
+	order  int
+	offset Point
+	scale  float64
+	trans  *mat.Dense
+}
+
+// The number of terms (and hence minimum number of points) for each order
+func polynomialTerms(order int) int {
+	return (order + 1) * (order + 2) / 2
+}
+
+func NewPolynomialTransformation(order int, eastingCoefficients, northingCoefficients []float64) (PolynomialTransformation, error) {
+	n := polynomialTerms(order)
+	if len(eastingCoefficients) != n || len(northingCoefficients) != n {
+		return PolynomialTransformation{}, fmt.Errorf("polynomial: order %v needs %v coefficients", order, n)
+	}
+
+	//   ----
+	// | a0 |
+	// | .. |
+	// | aN |
+	// | b0 |
+	// | .. |
+	// | bN |
+	//   ----
+	trans := mat.NewDense(2*n, 1, append(append([]float64{}, eastingCoefficients...), northingCoefficients...))
+	return PolynomialTransformation{order: order, scale: 1, trans: trans}, nil
+}
+
+// Needs at least 6 points for order 2 and 10 points for order 3, with any
+// extra points fitted in the least squares sense.
+func NewPolynomialTransformationFromPoints(order int, standardPoints []Point, localPoints []Point) (PolynomialTransformation, error) {
+	if order < 1 || order > 3 {
+		return PolynomialTransformation{}, fmt.Errorf("polynomial: unsupported order %v", order)
+	}
+	if len(standardPoints) != len(localPoints) {
+		return PolynomialTransformation{}, errors.New("polynomial: mismatched number of points")
+	}
+	terms := polynomialTerms(order)
+	if len(standardPoints) < terms {
+		return PolynomialTransformation{}, fmt.Errorf("polynomial: order %v needs at least %v points", order, terms)
+	}
+
+	t := PolynomialTransformation{order: order}
+	t.offset, t.scale = normalisation(localPoints)
+
+	//          X            *    t    =    e
+	//   ------------------        ----      ----
+	//  | 1 x1 y1 ..  0 .. |     | a0 |    | e1 |
+	//  | 0 ..      1 x1.. |  *  | .. | =  | n1 |
+	//  | .  .  .  ..  . . |     | bN |    |  . |
+	//   ------------------        ----      ----
+	n := len(standardPoints)
+	E := mat.NewDense(2*n, 1, nil)
+	X := mat.NewDense(2*n, 2*terms, nil)
+	for i := range standardPoints {
+		E.Set(2*i, 0, standardPoints[i].Lng)
+		E.Set(2*i+1, 0, standardPoints[i].Lat)
+		t.setRows(X, i, localPoints[i])
+	}
+
+	var trans mat.Dense
+	err := trans.Solve(X, E)
+	if err != nil {
+		return PolynomialTransformation{}, err
+	}
+	t.trans = &trans
+
+	return t, nil
+}
+
+// normalisation finds the centre of the points and how spread out they are
+func normalisation(points []Point) (offset Point, scale float64) {
+	for _, p := range points {
+		offset.Lat += p.Lat / float64(len(points))
+		offset.Lng += p.Lng / float64(len(points))
+	}
+	for _, p := range points {
+		scale = math.Max(scale, math.Max(math.Abs(p.Lat-offset.Lat), math.Abs(p.Lng-offset.Lng)))
+	}
+	if scale == 0 {
+		scale = 1
+	}
+	return
+}
+
+// setRows fills in the two rows of X for the i'th point, i.e. each term of
+// the polynomial: 1, x, y, x^2, x*y, y^2, x^3, ...
+func (t *PolynomialTransformation) setRows(X *mat.Dense, i int, p Point) {
+	terms := polynomialTerms(t.order)
+	x := (p.Lng - t.offset.Lng) / t.scale
+	y := (p.Lat - t.offset.Lat) / t.scale
+
+	col := 0
+	for degree := 0; degree <= t.order; degree++ {
+		for j := 0; j <= degree; j++ {
+			v := math.Pow(x, float64(degree-j)) * math.Pow(y, float64(j))
+			X.Set(2*i, col, v)
+			X.Set(2*i+1, terms+col, v)
+			col++
+		}
+	}
+}
+
+// Inverse has no closed form, so is found iteratively
+func (t *PolynomialTransformation) Inverse() Transformation {
+	terms := polynomialTerms(t.order)
+	linear := linearPart(t.offset, t.scale,
+		t.trans.At(0, 0), t.trans.At(1, 0), t.trans.At(2, 0),
+		t.trans.At(terms, 0), t.trans.At(terms+1, 0), t.trans.At(terms+2, 0))
+	inverse := NewIterativeInverseTransformation(t, linear.Inverse())
+	return &inverse
+}
+
+func (t *PolynomialTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *PolynomialTransformation) Projects(points ...Point) (results []Point) {
+	if len(points) == 0 {
+		return
+	}
+
+	X := mat.NewDense(2*len(points), 2*polynomialTerms(t.order), nil)
+	for i, p := range points {
+		t.setRows(X, i, p)
+	}
+
+	var transformed mat.Dense
+	transformed.Mul(X, t.trans)
+
+	for i, _ := range points {
+		easting := transformed.At(2*i, 0)
+		northing := transformed.At(2*i+1, 0)
+		results = append(results, Point{Lat: northing, Lng: easting})
+	}
+	return
+}
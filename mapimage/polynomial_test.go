@@ -0,0 +1,85 @@
+package mapimage
+
+import (
+	"gonum.org/v1/gonum/floats"
+	"testing"
+)
+
+// A grid of 5x5 points, such as reference points spread over a map image
+func polynomialTestGrid() (points []Point) {
+	for y := 0.0; y <= 4000; y += 1000 {
+		for x := 0.0; x <= 6000; x += 1500 {
+			points = append(points, PointXY(x, y))
+		}
+	}
+	return
+}
+
+func polynomialTestWarp(order int, p Point) Point {
+	// A gentle "paper shrinkage" style distortion
+	x, y := p.Lng/1000, p.Lat/1000
+	e := 26.2 + 0.01*x + 0.001*y + 0.0002*x*x - 0.0001*x*y + 0.00005*y*y
+	n := 40.3 - 0.008*y + 0.0005*x + 0.0001*x*x + 0.0003*y*y
+	if order == 3 {
+		e += 0.00002 * x * x * y
+		n -= 0.00001 * y * y * y
+	}
+	return PointEastingNorthing(e, n)
+}
+
+func TestPolynomialFromPoints(t *testing.T) {
+	for _, order := range []int{2, 3} {
+		local := polynomialTestGrid()
+		standard := make([]Point, len(local))
+		for i, pt := range local {
+			standard[i] = polynomialTestWarp(order, pt)
+		}
+
+		sut, err := NewPolynomialTransformationFromPoints(order, standard, local)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, pt := range []Point{PointXY(0, 0), PointXY(2500, 1700), PointXY(5999, 3999), PointXY(750, 3200)} {
+			result := sut.Project(pt)
+			expect := polynomialTestWarp(order, pt)
+			if !floats.EqualWithinAbs(result.Lat, expect.Lat, 1e-9) ||
+				!floats.EqualWithinAbs(result.Lng, expect.Lng, 1e-9) {
+				t.Errorf("order %v incorrect for %v, got: %v, want: %v.", order, pt, result, expect)
+			}
+		}
+
+		if residuals := NewResiduals(&sut, standard, local); residuals.RMSE > 1e-9 {
+			t.Errorf("order %v RMSE too large, got: %v", order, residuals.RMSE)
+		}
+	}
+}
+
+func TestPolynomialViaConstructor(t *testing.T) {
+	sut, err := NewPolynomialTransformation(2,
+		[]float64{1, 2, 0, 0, 0, 0},
+		[]float64{0, 0, 3, 0, 1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := sut.Project(PointXY(2, 5))
+	// e = 1 + 2x, n = 3y + xy
+	expect := PointEastingNorthing(5, 25)
+	if !result.IsCloseTo(expect) {
+		t.Errorf("incorrect, got: %v, want: %v.", result, expect)
+	}
+}
+
+func TestPolynomialNeedsEnoughPoints(t *testing.T) {
+	for order, n := range map[int]int{2: 6, 3: 10} {
+		local := polynomialTestGrid()[:n-1]
+		standard := make([]Point, len(local))
+		for i, pt := range local {
+			standard[i] = polynomialTestWarp(order, pt)
+		}
+		if _, err := NewPolynomialTransformationFromPoints(order, standard, local); err == nil {
+			t.Errorf("expected an error for order %v with %v points", order, n-1)
+		}
+	}
+}
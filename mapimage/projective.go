@@ -0,0 +1,147 @@
+package mapimage
+
+import (
+	"errors"
+	"gonum.org/v1/gonum/mat"
+	"math"
+)
+
+// ProjectiveTransformation is a homography, e.g. for a photo taken at an angle:
+//
+//	e = (h11*x + h12*y + h13) / (h31*x + h32*y + 1)
+//	n = (h21*x + h22*y + h23) / (h31*x + h32*y + 1)
+type ProjectiveTransformation struct {
+	trans *mat.Dense
+}
+
+func NewProjectiveTransformation(h11, h12, h13, h21, h22, h23, h31, h32 float64) ProjectiveTransformation {
+	//   --              --
+	// | h11  h12  h13  |
+	// | h21  h22  h23  |
+	// | h31  h32   1   |
+	//   --              --
+	trans := mat.NewDense(3, 3, []float64{
+		h11, h12, h13,
+		h21, h22, h23,
+		h31, h32, 1,
+	})
+	return ProjectiveTransformation{trans}
+}
+
+// Needs at least 4 points (no 3 of which are in a line), with any extra
+// points fitted in the least squares sense.
+func NewProjectiveTransformationFromPoints(standardPoints []Point, localPoints []Point) (ProjectiveTransformation, error) {
+	if len(standardPoints) != len(localPoints) {
+		return ProjectiveTransformation{}, errors.New("projective: mismatched number of points")
+	}
+	if len(standardPoints) < 4 {
+		return ProjectiveTransformation{}, errors.New("projective: at least 4 points are required")
+	}
+
+	// Work with normalised points (centred, and scaled to about +/-1) so that
+	// the x*e terms don't swamp the others
+	standardOffset, standardScale := normalisation(standardPoints)
+	localOffset, localScale := normalisation(localPoints)
+
+	// Multiplying out the denominator gives two linear equations per point:
+	//
+	//                 X                    *     t    =   e
+	//   -----------------------------------     -----    ----
+	//  | x1 y1  1  0  0  0 -x1*e1 -y1*e1  |    | h11 |  | e1 |
+	//  |  0  0  0 x1 y1  1 -x1*n1 -y1*n1  |  * | ... | =| n1 |
+	//  |  .  .  .  .  .  .    .      .    |    | h32 |  |  . |
+	//   -----------------------------------     -----    ----
+	n := len(standardPoints)
+	E := mat.NewDense(2*n, 1, nil)
+	X := mat.NewDense(2*n, 8, nil)
+	for i := range standardPoints {
+		e := (standardPoints[i].Lng - standardOffset.Lng) / standardScale
+		nn := (standardPoints[i].Lat - standardOffset.Lat) / standardScale
+		x := (localPoints[i].Lng - localOffset.Lng) / localScale
+		y := (localPoints[i].Lat - localOffset.Lat) / localScale
+
+		E.Set(2*i, 0, e)
+		E.Set(2*i+1, 0, nn)
+		X.SetRow(2*i, []float64{x, y, 1, 0, 0, 0, -x * e, -y * e})
+		X.SetRow(2*i+1, []float64{0, 0, 0, x, y, 1, -x * nn, -y * nn})
+	}
+
+	var h mat.Dense
+	err := h.Solve(X, E)
+	if err != nil {
+		return ProjectiveTransformation{}, err
+	}
+	normalised := mat.NewDense(3, 3, []float64{
+		h.At(0, 0), h.At(1, 0), h.At(2, 0),
+		h.At(3, 0), h.At(4, 0), h.At(5, 0),
+		h.At(6, 0), h.At(7, 0), 1,
+	})
+
+	// Then undo the normalisation: H = S^-1 * normalised * L
+	standardInv := mat.NewDense(3, 3, []float64{
+		standardScale, 0, standardOffset.Lng,
+		0, standardScale, standardOffset.Lat,
+		0, 0, 1,
+	})
+	local := mat.NewDense(3, 3, []float64{
+		1 / localScale, 0, -localOffset.Lng / localScale,
+		0, 1 / localScale, -localOffset.Lat / localScale,
+		0, 0, 1,
+	})
+	var trans mat.Dense
+	trans.Product(standardInv, normalised, local)
+	if trans.At(2, 2) == 0 {
+		return ProjectiveTransformation{}, errors.New("projective: degenerate transformation")
+	}
+
+	t := ProjectiveTransformation{&trans}
+	t.normalise()
+	return t, nil
+}
+
+// normalise scales the matrix so that h33 is 1
+func (t *ProjectiveTransformation) normalise() {
+	t.trans.Scale(1/t.trans.At(2, 2), t.trans)
+}
+
+// Inverse is exact, as it is just the inverse of the matrix
+func (t *ProjectiveTransformation) Inverse() Transformation {
+	var inv mat.Dense
+	err := inv.Inverse(t.trans)
+	if _, ok := err.(mat.Condition); err != nil && !ok {
+		// There is no inverse
+		inv.Apply(func(i, j int, v float64) float64 { return math.NaN() }, t.trans)
+	}
+	inverse := ProjectiveTransformation{&inv}
+	inverse.normalise()
+	return &inverse
+}
+
+func (t *ProjectiveTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *ProjectiveTransformation) Projects(points ...Point) (results []Point) {
+	if len(points) == 0 {
+		return
+	}
+
+	// Each point as a column of homogeneous coordinates (x, y, 1)
+	X := mat.NewDense(3, len(points), nil)
+	for i, p := range points {
+		X.Set(0, i, p.Lng)
+		X.Set(1, i, p.Lat)
+		X.Set(2, i, 1)
+	}
+
+	var transformed mat.Dense
+	transformed.Mul(t.trans, X)
+
+	for i, _ := range points {
+		w := transformed.At(2, i)
+		easting := transformed.At(0, i) / w
+		northing := transformed.At(1, i) / w
+		results = append(results, Point{Lat: northing, Lng: easting})
+	}
+	return
+}
@@ -0,0 +1,66 @@
+package mapimage
+
+import (
+	"gonum.org/v1/gonum/floats"
+	"testing"
+)
+
+// A map photographed from below and to the left
+var projectiveTestParams = NewProjectiveTransformation(
+	0.9, 0.15, 20,
+	-0.05, 1.1, 35,
+	0.0002, 0.0001,
+)
+
+func TestProjectiveViaConstructor(t *testing.T) {
+	result := projectiveTestParams.Project(PointXY(100, 200))
+	w := 0.0002*100 + 0.0001*200 + 1
+	expect := PointXY((0.9*100+0.15*200+20)/w, (-0.05*100+1.1*200+35)/w)
+	if !result.IsCloseTo(expect) {
+		t.Errorf("incorrect, got: %v, want: %v.", result, expect)
+	}
+}
+
+func TestProjectiveFromPoints(t *testing.T) {
+	for _, local := range [][]Point{
+		// Exactly four points
+		{PointXY(0, 0), PointXY(3000, 0), PointXY(3000, 2000), PointXY(0, 2000)},
+		// More than enough
+		{PointXY(0, 0), PointXY(3000, 0), PointXY(3000, 2000), PointXY(0, 2000), PointXY(1500, 1000), PointXY(700, 1800)},
+	} {
+		standard := projectiveTestParams.Projects(local...)
+
+		sut, err := NewProjectiveTransformationFromPoints(standard, local)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, pt := range []Point{PointXY(10, 10), PointXY(2500, 1200), PointXY(-400, 2600)} {
+			result := sut.Project(pt)
+			expect := projectiveTestParams.Project(pt)
+			if !floats.EqualWithinAbs(result.Lat, expect.Lat, 1e-6) ||
+				!floats.EqualWithinAbs(result.Lng, expect.Lng, 1e-6) {
+				t.Errorf("incorrect for %v, got: %v, want: %v.", pt, result, expect)
+			}
+		}
+	}
+}
+
+func TestProjectiveInverse(t *testing.T) {
+	inverse := projectiveTestParams.Inverse()
+
+	for _, pt := range []Point{PointXY(0, 0), PointXY(2500, 1200), PointXY(-400, 2600)} {
+		result := inverse.Project(projectiveTestParams.Project(pt))
+		if !floats.EqualWithinAbs(result.Lat, pt.Lat, 1e-6) ||
+			!floats.EqualWithinAbs(result.Lng, pt.Lng, 1e-6) {
+			t.Errorf("round trip incorrect for %v, got: %v.", pt, result)
+		}
+	}
+}
+
+func TestProjectiveNeedsFourPoints(t *testing.T) {
+	points := []Point{PointXY(0, 0), PointXY(1, 0), PointXY(0, 1)}
+	if _, err := NewProjectiveTransformationFromPoints(points, points); err == nil {
+		t.Errorf("expected an error for only three points")
+	}
+}
@@ -0,0 +1,121 @@
+package mapimage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// wgs84WKT is WGS84 lat/lng as (version 1) WKT, which is how QGIS and GDAL
+// are told the CRS of the points that we export
+const wgs84WKT = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]]`
+
+// wktEPSG finds the EPSG codes in WKT (version 1 or 2), the last of which is
+// the one for the CRS as a whole
+var wktEPSG = regexp.MustCompile(`(?:ID|AUTHORITY)\[\s*"EPSG"\s*,\s*"?(\d+)"?\s*\]`)
+
+// ParseQGISPoints reads the enabled points saved by the QGIS Georeferencer,
+// and the CRS from a "#CRS:" line, when there is one
+func ParseQGISPoints(r io.Reader) (Calibration, error) {
+	var c Calibration
+	columns := map[string]int{"mapX": 0, "mapY": 1, "pixelX": 2, "pixelY": 3, "enable": 4}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+
+		case strings.HasPrefix(line, "#CRS:"):
+			if m := wktEPSG.FindAllStringSubmatch(line, -1); m != nil {
+				c.CRS = "EPSG:" + m[len(m)-1][1]
+			}
+			continue
+
+		case strings.HasPrefix(line, "#"):
+			continue
+
+		case strings.HasPrefix(line, "mapX"):
+			for i, name := range strings.Split(line, ",") {
+				columns[strings.TrimSpace(name)] = i
+			}
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		value := func(column string) (float64, error) {
+			i := columns[column]
+			if i >= len(fields) {
+				return 0, fmt.Errorf("qgis: no %v in %q", column, line)
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(fields[i]), 64)
+			if err != nil {
+				return 0, fmt.Errorf("qgis: bad %v in %q", column, line)
+			}
+			return v, nil
+		}
+
+		var v [4]float64
+		for i, column := range []string{"mapX", "mapY", "pixelX", "pixelY"} {
+			var err error
+			if v[i], err = value(column); err != nil {
+				return Calibration{}, err
+			}
+		}
+		if i := columns["enable"]; i < len(fields) && strings.TrimSpace(fields[i]) == "0" {
+			continue
+		}
+		c.ReferencePoints = append(c.ReferencePoints, MapImagePair{
+			Geographic: LatLng{Lat: v[1], Lng: v[0]},
+			Pixel:      LatLng{Lat: -v[3], Lng: v[2]},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return Calibration{}, err
+	}
+	if len(c.ReferencePoints) == 0 {
+		return Calibration{}, errors.New("qgis: no enabled points")
+	}
+	return c, nil
+}
+
+// WriteQGISPoints writes the reference points (with the outliers disabled) for
+// the QGIS Georeferencer
+func WriteQGISPoints(w io.Writer, i MapImage) error {
+	g := i.Georeference()
+	referencePoints := exportReferencePoints(i)
+	if _, err := fmt.Fprintf(w, "#CRS: %s\nmapX,mapY,pixelX,pixelY,enable,dX,dY,residual\n", wgs84WKT); err != nil {
+		return err
+	}
+
+	format := func(v float64) string {
+		if v == 0 {
+			v = 0 // (not -0)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	for n, rp := range append(append([]MapImagePair{}, referencePoints...), g.Outliers...) {
+		enable := 1
+		if n >= len(referencePoints) {
+			enable = 0
+		}
+		projected := g.PixelFromGeo(rp.Geographic)
+		dX, dY := projected.Lng-rp.Pixel.Lng, -(projected.Lat - rp.Pixel.Lat)
+
+		_, err := fmt.Fprintf(w, "%s,%s,%s,%s,%d,%s,%s,%s\n",
+			format(rp.Geographic.Lng), format(rp.Geographic.Lat),
+			format(rp.Pixel.Lng), format(-rp.Pixel.Lat),
+			enable, format(dX), format(dY), format(math.Hypot(dX, dY)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package mapimage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestParseQGISPoints(t *testing.T) {
+	sut, err := ParseQGISPoints(strings.NewReader(`#CRS: GEOGCRS["WGS 84",DATUM["World Geodetic System 1984",ELLIPSOID["WGS 84",6378137,298.257223563,LENGTHUNIT["metre",1]],ID["EPSG",6326]],PRIMEM["Greenwich",0,ANGLEUNIT["degree",0.0174532925199433],ID["EPSG",8901]],CS[ellipsoidal,2],ID["EPSG",4326]]
+mapX,mapY,pixelX,pixelY,enable,dX,dY,residual
+26.25,40.4166666667,2531,-1756,1,0.2,-0.1,0.22
+26.5,40.0,7128,-13140,1,0,0,0
+26.4,40.2,5532,-7736,0,12,3,12.4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sut.CRS != "EPSG:4326" {
+		t.Errorf("incorrect CRS, got: %q, want: EPSG:4326.", sut.CRS)
+	}
+
+	// (The point that isn't enabled is left out)
+	expected := []MapImagePair{
+		{Geographic: LatLng{Lat: 40.4166666667, Lng: 26.25}, Pixel: LatLng{Lat: 1756, Lng: 2531}},
+		{Geographic: LatLng{Lat: 40, Lng: 26.5}, Pixel: LatLng{Lat: 13140, Lng: 7128}},
+	}
+	if len(sut.ReferencePoints) != len(expected) {
+		t.Fatalf("incorrect, got: %v, want: %v.", sut.ReferencePoints, expected)
+	}
+	for i, rp := range sut.ReferencePoints {
+		if rp != expected[i] {
+			t.Errorf("incorrect for point %v, got: %v, want: %v.", i, rp, expected[i])
+		}
+	}
+}
+
+func TestParseOldQGISPoints(t *testing.T) {
+	// No CRS, and projected coordinates
+	sut, err := ParseQGISPoints(strings.NewReader("mapX,mapY,pixelX,pixelY,enable\n453120,4455800,0,0,1\n469120,4455400,8000,-200,1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sut.CRS != "" || len(sut.ReferencePoints) != 2 || sut.ReferencePoints[1].Pixel != (LatLng{Lat: 200, Lng: 8000}) {
+		t.Errorf("incorrect, got: %v.", sut)
+	}
+}
+
+func TestBadQGISPoints(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"mapX,mapY,pixelX,pixelY,enable\n",
+		"mapX,mapY,pixelX,pixelY,enable\n26.25,40.4,2531,-1756,0\n",
+		"mapX,mapY,pixelX,pixelY,enable\n26.25,40.4,2531\n",
+		"mapX,mapY,pixelX,pixelY,enable\n26.25,north,2531,-1756,1\n",
+	} {
+		if _, err := ParseQGISPoints(strings.NewReader(content)); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
+
+func TestQGISPointsRoundTrip(t *testing.T) {
+	// It has no reference points, so its corners (and centre) are used
+	mi := testMapImage(t, TileOptions{})
+
+	var buf bytes.Buffer
+	if err := WriteQGISPoints(&buf, mi); err != nil {
+		t.Fatal(err)
+	}
+	sut, err := ParseQGISPoints(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sut.CRS != "EPSG:4326" || len(sut.ReferencePoints) != 5 {
+		t.Fatalf("incorrect, got: %v.", sut)
+	}
+	for _, rp := range sut.ReferencePoints {
+		expected := mi.GeoFromPixel(rp.Pixel)
+		if !rp.Geographic.toPoint().IsCloseTo(expected.toPoint()) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", rp.Pixel, rp.Geographic, expected)
+		}
+	}
+}
+
+func TestWriteVRT(t *testing.T) {
+	mi := testMapImage(t, TileOptions{})
+
+	var buf bytes.Buffer
+	if err := WriteVRT(&buf, mi, "/maps/cog.png"); err != nil {
+		t.Fatal(err)
+	}
+	var sut vrtDataset
+	if err := xml.Unmarshal(buf.Bytes(), &sut); err != nil {
+		t.Fatal(err)
+	}
+
+	if sut.Width != 600 || sut.Height != 400 || len(sut.GCPs.GCPs) != 5 {
+		t.Errorf("incorrect, got: %vx%v with %v GCPs.", sut.Width, sut.Height, len(sut.GCPs.GCPs))
+	}
+	if !strings.Contains(sut.GCPs.Projection, `AUTHORITY["EPSG","4326"]`) {
+		t.Errorf("incorrect projection, got: %v.", sut.GCPs.Projection)
+	}
+	for _, gcp := range sut.GCPs.GCPs {
+		expected := mi.GeoFromPixel(LatLng{Lat: gcp.Line, Lng: gcp.Pixel})
+		if !PointNorthingEasting(gcp.Y, gcp.X).IsCloseTo(expected.toPoint()) {
+			t.Errorf("incorrect for GCP %v, got: %v, want: %v.", gcp.Id, gcp, expected)
+		}
+	}
+
+	// An RGBA PNG
+	if len(sut.Bands) != 4 || sut.Bands[3].ColorInterp != "Alpha" || sut.Bands[0].Source.Filename.Name != "/maps/cog.png" {
+		t.Errorf("incorrect bands, got: %v.", sut.Bands)
+	}
+}
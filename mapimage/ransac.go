@@ -0,0 +1,111 @@
+package mapimage
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// minimumPoints is how many reference points each model needs to be fitted
+var minimumPoints = map[string]int{
+	AffineNoRotModel:     2,
+	ConformalModel:       2,
+	AffineModel:          3,
+	ProjectiveModel:      4,
+	Polynomial2Model:     6,
+	Polynomial3Model:     10,
+	ThinPlateSplineModel: 3,
+	PiecewiseAffineModel: 3,
+}
+
+// consensusModels are used instead when looking for outliers, for the models
+// that pass exactly through every point (so would agree with any outlier)
+var consensusModels = map[string]string{
+	ThinPlateSplineModel: AffineModel,
+	PiecewiseAffineModel: AffineModel,
+}
+
+const ransacIterations = 500
+
+// rejectOutliers uses RANSAC (seeded the same every time) to find the points
+// more than threshold pixels from where a fit to the others puts them
+func rejectOutliers(model string, crs CRS, referencePoints []MapImagePair, threshold float64) (inliers, outliers []MapImagePair, err error) {
+	consensusModel := model
+	if m, ok := consensusModels[model]; ok {
+		consensusModel = m
+	}
+	k, ok := minimumPoints[consensusModel]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown transformation %q", model)
+	}
+
+	// Every sample would agree with itself, so there is nothing to compare
+	n := len(referencePoints)
+	if n <= k {
+		return referencePoints, nil, nil
+	}
+
+	// Where each point is, to compare against the transformations
+	geo, pixel := splitReferencePoints(crs.referencePointsToLatLng(referencePoints))
+
+	rng := rand.New(rand.NewSource(1))
+	var best []bool
+	bestCount, bestSumSq := 0, math.Inf(1)
+	sample := make([]MapImagePair, k)
+	for iter := 0; iter < ransacIterations; iter++ {
+		for i, j := range rng.Perm(n)[:k] {
+			sample[i] = referencePoints[j]
+		}
+		agree, count, sumSq := consensus(consensusModel, crs, sample, geo, pixel, threshold)
+		if count > bestCount || (count == bestCount && sumSq < bestSumSq) {
+			best, bestCount, bestSumSq = agree, count, sumSq
+		}
+	}
+	if bestCount < k {
+		return nil, nil, fmt.Errorf("no %v transformation agrees with %v of the reference points", consensusModel, k)
+	}
+
+	// Then refit with everything that agreed, which can only make it better
+	var agreed []MapImagePair
+	for i, rp := range referencePoints {
+		if best[i] {
+			agreed = append(agreed, rp)
+		}
+	}
+	if agree, count, _ := consensus(consensusModel, crs, agreed, geo, pixel, threshold); count >= bestCount {
+		best, bestCount = agree, count
+	}
+
+	if need := minimumPoints[model]; bestCount < need {
+		return nil, nil, fmt.Errorf("only %v reference points are not outliers, but %v needs %v", bestCount, model, need)
+	}
+	for i, rp := range referencePoints {
+		if best[i] {
+			inliers = append(inliers, rp)
+		} else {
+			outliers = append(outliers, rp)
+		}
+	}
+	return inliers, outliers, nil
+}
+
+// consensus fits the model to the sample, and then works out which of the
+// points (geo being WGS84 lat/lng) agree with it, to within threshold pixels
+func consensus(model string, crs CRS, sample []MapImagePair, geo, pixel []Point, threshold float64) (agree []bool, count int, sumSq float64) {
+	agree = make([]bool, len(geo))
+	toGeo, err := NewTransformationFromReferencePoints(model, crs, sample)
+	if err != nil {
+		return
+	}
+
+	for i, projected := range toGeo.Inverse().Projects(geo...) {
+		d := math.Hypot(projected.Lng-pixel[i].Lng, projected.Lat-pixel[i].Lat)
+		// (NB: written this way around so NaNs never agree)
+		if d <= threshold {
+			agree[i] = true
+			count++
+			sumSq += d * d
+		}
+	}
+	return
+}
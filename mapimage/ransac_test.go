@@ -0,0 +1,80 @@
+package mapimage
+
+import (
+	"testing"
+)
+
+// A 5x4 grid of reference points, that the model fits exactly (or for the
+// models that are checked with an affine transformation, that fits exactly)
+func ransacTestReferencePoints(t *testing.T, model string) []MapImagePair {
+	if m, ok := consensusModels[model]; ok {
+		model = m
+	}
+	truth, err := NewTransformationFromReferencePoints(model, LatLngCRS, transformationTestReferencePoints())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var referencePoints []MapImagePair
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 4; j++ {
+			pixel := PointXY(float64(i)*2000+float64(j)*130, float64(j)*2500+float64(i)*70)
+			referencePoints = append(referencePoints, MapImagePair{
+				Geographic: LatLng(truth.Project(pixel)),
+				Pixel:      LatLng(pixel),
+			})
+		}
+	}
+	return referencePoints
+}
+
+func TestRansacFindsMistypedPoint(t *testing.T) {
+	for _, model := range transformationTestModels {
+		referencePoints := ransacTestReferencePoints(t, model)
+		// Oops, two of the digits are the wrong way around
+		mistyped := referencePoints[7]
+		mistyped.Geographic.Lat += 0.027
+		referencePoints[7] = mistyped
+
+		sut, err := NewGeoreference(model, LatLngCRS, referencePoints, 5)
+		if err != nil {
+			t.Errorf("%v: %v", model, err)
+			continue
+		}
+
+		if len(sut.Outliers) != 1 || sut.Outliers[0] != mistyped {
+			t.Errorf("%v: incorrect outliers, got: %v, want: %v.", model, sut.Outliers, mistyped)
+		}
+		if len(sut.ReferencePoints) != len(referencePoints)-1 {
+			t.Errorf("%v: incorrect number of reference points, got: %v, want: %v.", model, len(sut.ReferencePoints), len(referencePoints)-1)
+		}
+	}
+}
+
+func TestRansacKeepsGoodPoints(t *testing.T) {
+	referencePoints := ransacTestReferencePoints(t, AffineModel)
+	sut, err := NewGeoreference(AffineModel, LatLngCRS, referencePoints, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sut.Outliers) != 0 {
+		t.Errorf("incorrect outliers, got: %v, want none.", sut.Outliers)
+	}
+	if residuals := sut.PixelResiduals(); residuals.RMSE > 1e-6 {
+		t.Errorf("incorrect RMSE, got: %v, want: 0.", residuals.RMSE)
+	}
+}
+
+func TestRansacIsOffByDefault(t *testing.T) {
+	referencePoints := ransacTestReferencePoints(t, AffineModel)
+	referencePoints[3].Geographic.Lng += 0.5
+
+	sut, err := NewGeoreference(AffineModel, LatLngCRS, referencePoints, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sut.Outliers) != 0 || len(sut.ReferencePoints) != len(referencePoints) {
+		t.Errorf("expected every reference point to be used, got outliers: %v", sut.Outliers)
+	}
+}
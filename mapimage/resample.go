@@ -0,0 +1,226 @@
+package mapimage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Kernel is how the source image is resampled to draw a tile
+type Kernel string
+
+const (
+	NearestKernel    Kernel = "nearest"
+	BilinearKernel   Kernel = "bilinear"
+	CatmullRomKernel Kernel = "catmull-rom"
+	LanczosKernel    Kernel = "lanczos"
+	// AreaKernel averages all of the source pixels under each tile pixel,
+	// for downsampling (it is bilinear when upsampling)
+	AreaKernel Kernel = "area"
+)
+
+const (
+	// maxKernelScale is as far as Catmull-Rom and Lanczos are widened when
+	// downsampling, past that they alias (area doesn't)
+	maxKernelScale = 2
+	// maxAreaSamples is the most source pixels that area samples across each
+	// tile pixel, any more are skipped over
+	maxAreaSamples = 8
+)
+
+func ParseKernel(s string) (Kernel, error) {
+	switch k := Kernel(strings.ToLower(strings.TrimSpace(s))); k {
+	case NearestKernel, BilinearKernel, CatmullRomKernel, LanczosKernel, AreaKernel:
+		return k, nil
+	case "", "linear":
+		return BilinearKernel, nil
+	case "catmullrom", "bicubic":
+		return CatmullRomKernel, nil
+	case "lanczos3":
+		return LanczosKernel, nil
+	}
+	return "", fmt.Errorf("unknown resampling kernel %q, expected nearest, bilinear, catmull-rom, lanczos or area", s)
+}
+
+func (k *Kernel) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseKernel(s)
+	if err != nil {
+		return err
+	}
+	*k = parsed
+	return nil
+}
+
+// ZoomKernel is the kernel for the zooms from MinZoom to MaxZoom (inclusive,
+// either can be left out)
+type ZoomKernel struct {
+	Kernel  Kernel `json:"kernel"`
+	MinZoom *int64 `json:"minZoom"`
+	MaxZoom *int64 `json:"maxZoom"`
+}
+
+// Resampling is the kernel for each zoom, the first ZoomKernel that includes
+// the zoom is used (and bilinear when none do)
+type Resampling []ZoomKernel
+
+// UnmarshalJSON also accepts just the name of a kernel for every zoom, e.g.
+// "lanczos"
+func (r *Resampling) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		k, err := ParseKernel(name)
+		if err != nil {
+			return err
+		}
+		*r = Resampling{{Kernel: k}}
+		return nil
+	}
+
+	var zooms []ZoomKernel
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&zooms); err != nil {
+		return err
+	}
+	for _, z := range zooms {
+		if z.Kernel == "" {
+			return fmt.Errorf("resampling: no kernel for zooms %v-%v", z.MinZoom, z.MaxZoom)
+		}
+	}
+	*r = zooms
+	return nil
+}
+
+// Kernel is the kernel for the zoom
+func (r Resampling) Kernel(zoom int64) Kernel {
+	for _, z := range r {
+		if (z.MinZoom == nil || zoom >= *z.MinZoom) && (z.MaxZoom == nil || zoom <= *z.MaxZoom) {
+			return z.Kernel
+		}
+	}
+	return BilinearKernel
+}
+
+// sample is the colour of src at the (fractional) position u, v, where
+// (scaleX, scaleY) source pixels are under each pixel of the tile
+func (k Kernel) sample(src image.Image, u, v, scaleX, scaleY float64) color.RGBA {
+	switch k {
+	case NearestKernel:
+		bounds := src.Bounds()
+		x := max(bounds.Min.X, min(int(math.Floor(u)), bounds.Max.X-1))
+		y := max(bounds.Min.Y, min(int(math.Floor(v)), bounds.Max.Y-1))
+		return color.RGBAModel.Convert(src.At(x, y)).(color.RGBA)
+	case CatmullRomKernel:
+		return convolve(src, u, v, scaleX, scaleY, 2, catmullRom)
+	case LanczosKernel:
+		return convolve(src, u, v, scaleX, scaleY, 3, lanczos3)
+	case AreaKernel:
+		if scaleX > 1 || scaleY > 1 {
+			return areaAverage(src, u, v, scaleX, scaleY)
+		}
+	}
+	return bilinear(src, u, v)
+}
+
+func catmullRom(t float64) float64 {
+	t = math.Abs(t)
+	if t < 1 {
+		return (1.5*t-2.5)*t*t + 1
+	}
+	return ((-0.5*t+2.5)*t-4)*t + 2
+}
+
+func lanczos3(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	t *= math.Pi
+	return 3 * math.Sin(t) * math.Sin(t/3) / (t * t)
+}
+
+// convolve samples src with the kernel, widened by the scale when downsampling
+func convolve(src image.Image, u, v, scaleX, scaleY, radius float64, kernel func(float64) float64) color.RGBA {
+	bounds := src.Bounds()
+	scaleX = math.Max(1, math.Min(scaleX, maxKernelScale))
+	scaleY = math.Max(1, math.Min(scaleY, maxKernelScale))
+
+	// The weights along each axis, for the pixels whose centres are within
+	// the kernel
+	weights := func(c, scale float64, lo, hi int) ([]int, []float64) {
+		var is []int
+		var ws []float64
+		for i := int(math.Ceil(c - radius*scale)); float64(i) <= c+radius*scale; i++ {
+			if w := kernel((float64(i) - c) / scale); w != 0 {
+				is = append(is, max(lo, min(i, hi-1)))
+				ws = append(ws, w)
+			}
+		}
+		return is, ws
+	}
+	xs, wxs := weights(u-0.5, scaleX, bounds.Min.X, bounds.Max.X)
+	ys, wys := weights(v-0.5, scaleY, bounds.Min.Y, bounds.Max.Y)
+
+	var r, g, b, a, total float64
+	for j, y := range ys {
+		for i, x := range xs {
+			w := wxs[i] * wys[j]
+			sr, sg, sb, sa := src.At(x, y).RGBA()
+			r += float64(sr) * w
+			g += float64(sg) * w
+			b += float64(sb) * w
+			a += float64(sa) * w
+			total += w
+		}
+	}
+
+	// The negative lobes can overshoot, and the colour (premultiplied)
+	// can't be more than the alpha
+	clamp := func(v, hi float64) uint8 {
+		return uint8(uint32(math.Max(0, math.Min(v/total, hi))) >> 8)
+	}
+	alpha := math.Max(0, math.Min(a/total, 0xffff))
+	return color.RGBA{
+		R: clamp(r, alpha),
+		G: clamp(g, alpha),
+		B: clamp(b, alpha),
+		A: clamp(a, 0xffff),
+	}
+}
+
+// areaAverage is the mean of the source pixels under the tile pixel
+func areaAverage(src image.Image, u, v, scaleX, scaleY float64) color.RGBA {
+	bounds := src.Bounds()
+	nx := min(maxAreaSamples, max(1, int(math.Ceil(scaleX))))
+	ny := min(maxAreaSamples, max(1, int(math.Ceil(scaleY))))
+
+	var r, g, b, a float64
+	for j := 0; j < ny; j++ {
+		sy := v + scaleY*((float64(j)+0.5)/float64(ny)-0.5)
+		y := max(bounds.Min.Y, min(int(math.Floor(sy)), bounds.Max.Y-1))
+		for i := 0; i < nx; i++ {
+			sx := u + scaleX*((float64(i)+0.5)/float64(nx)-0.5)
+			x := max(bounds.Min.X, min(int(math.Floor(sx)), bounds.Max.X-1))
+			sr, sg, sb, sa := src.At(x, y).RGBA()
+			r += float64(sr)
+			g += float64(sg)
+			b += float64(sb)
+			a += float64(sa)
+		}
+	}
+
+	n := float64(nx * ny)
+	return color.RGBA{
+		R: uint8(uint32(r/n) >> 8),
+		G: uint8(uint32(g/n) >> 8),
+		B: uint8(uint32(b/n) >> 8),
+		A: uint8(uint32(a/n) >> 8),
+	}
+}
@@ -0,0 +1,100 @@
+package mapimage
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestUnmarshalResampling(t *testing.T) {
+	var sut Resampling
+	if err := json.Unmarshal([]byte(`"Lanczos"`), &sut); err != nil {
+		t.Fatal(err)
+	}
+	if len(sut) != 1 || sut.Kernel(3) != LanczosKernel || sut.Kernel(20) != LanczosKernel {
+		t.Errorf("incorrect, got: %v.", sut)
+	}
+
+	sut = nil
+	if err := json.Unmarshal([]byte(`[{"kernel": "area", "maxZoom": 12}, {"kernel": "nearest", "minZoom": 18}, {"kernel": "catmull-rom", "minZoom": 13, "maxZoom": 15}]`), &sut); err != nil {
+		t.Fatal(err)
+	}
+	for zoom, expected := range map[int64]Kernel{
+		0:  AreaKernel,
+		12: AreaKernel,
+		13: CatmullRomKernel,
+		15: CatmullRomKernel,
+		16: BilinearKernel,
+		18: NearestKernel,
+		21: NearestKernel,
+	} {
+		if result := sut.Kernel(zoom); result != expected {
+			t.Errorf("incorrect for zoom %v, got: %v, want: %v.", zoom, result, expected)
+		}
+	}
+
+	for _, bad := range []string{`"sharp"`, `[{"kernel": "box"}]`, `[{"maxZoom": 3}]`, `[{"kernel": "area", "zoom": 3}]`} {
+		if err := json.Unmarshal([]byte(bad), &sut); err == nil {
+			t.Errorf("expected an error for %v", bad)
+		}
+	}
+}
+
+// checkerboard is black and white pixels, all opaque
+func checkerboard() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if (x+y)%2 == 0 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	return img
+}
+
+func TestKernels(t *testing.T) {
+	src := checkerboard()
+	grey := func(c color.RGBA) float64 {
+		return float64(c.R) / 255
+	}
+
+	// At the centre of a pixel, every kernel gives that pixel (the
+	// interpolating ones at least, and area when not downsampling)
+	for _, k := range []Kernel{NearestKernel, BilinearKernel, CatmullRomKernel, LanczosKernel, AreaKernel, ""} {
+		if result := k.sample(src, 4.5, 6.5, 1, 1); result != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+			t.Errorf("incorrect for %q at a pixel centre, got: %v.", k, result)
+		}
+	}
+
+	// Between 4 pixels, nearest picks one and the others blend them
+	if result := NearestKernel.sample(src, 5, 7, 1, 1); result != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("incorrect for nearest, got: %v.", result)
+	}
+	for _, k := range []Kernel{BilinearKernel, CatmullRomKernel, LanczosKernel} {
+		if result := grey(k.sample(src, 5, 7, 1, 1)); result < 0.45 || result > 0.55 {
+			t.Errorf("incorrect for %v between pixels, got: %v, want: 0.5.", k, result)
+		}
+	}
+
+	// Downsampling by 4 at a pixel centre, the checkerboard averages out
+	// to grey rather than aliasing to white
+	for _, k := range []Kernel{CatmullRomKernel, LanczosKernel, AreaKernel} {
+		if result := grey(k.sample(src, 8.5, 8.5, 4, 4)); result < 0.4 || result > 0.6 {
+			t.Errorf("incorrect for %v downsampling, got: %v, want: 0.5.", k, result)
+		}
+	}
+	if result := BilinearKernel.sample(src, 8.5, 8.5, 4, 4); result.R != 255 {
+		t.Errorf("incorrect for bilinear downsampling, got: %v.", result)
+	}
+
+	// The edges are repeated, rather than fading to transparent
+	for _, k := range []Kernel{CatmullRomKernel, LanczosKernel, AreaKernel} {
+		if result := k.sample(src, 0.5, 0.5, 2, 2); result.A != 255 {
+			t.Errorf("incorrect for %v at the edge, got: %v.", k, result)
+		}
+	}
+}
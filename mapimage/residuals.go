@@ -0,0 +1,42 @@
+package mapimage
+
+import (
+	"math"
+)
+
+// Residual is how far a fitted transformation misses one of the points it
+// was fitted from
+type Residual struct {
+	Expected  Point   `json:"expected"`
+	Projected Point   `json:"projected"`
+	Distance  float64 `json:"distance"`
+}
+
+type Residuals struct {
+	Points []Residual `json:"points"`
+	RMSE   float64    `json:"rmse"`
+}
+
+// NewResiduals is how far t puts each of the localPoints from its standardPoint
+func NewResiduals(t Transformation, standardPoints []Point, localPoints []Point) Residuals {
+	r := Residuals{Points: make([]Residual, 0, len(standardPoints))}
+	if len(standardPoints) == 0 {
+		return r
+	}
+
+	sumSq := 0.0
+	for i, projected := range t.Projects(localPoints...) {
+		expected := standardPoints[i]
+		distance := math.Hypot(projected.Lng-expected.Lng, projected.Lat-expected.Lat)
+		sumSq += distance * distance
+
+		r.Points = append(r.Points, Residual{
+			Expected:  expected,
+			Projected: projected,
+			Distance:  distance,
+		})
+	}
+	r.RMSE = math.Sqrt(sumSq / float64(len(standardPoints)))
+
+	return r
+}
@@ -0,0 +1,166 @@
+package mapimage
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// TileFormat is how a map tile is encoded
+type TileFormat string
+
+const (
+	PNGTile  TileFormat = "png"
+	JPEGTile TileFormat = "jpeg"
+	WebPTile TileFormat = "webp"
+)
+
+// tileFormats are the formats in the order they are picked in, when a client
+// doesn't mind which
+var tileFormats = []TileFormat{PNGTile, JPEGTile, WebPTile}
+
+// defaultTileQuality is the JPEG and WebP quality when it isn't set
+const defaultTileQuality = 80
+
+// ParseTileFormat reads a format name or file extension (png, jpg, jpeg or
+// webp). An empty string is the default, i.e. PNG.
+func ParseTileFormat(s string) (TileFormat, error) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), ".")) {
+	case "", "png":
+		return PNGTile, nil
+	case "jpg", "jpeg":
+		return JPEGTile, nil
+	case "webp":
+		return WebPTile, nil
+	}
+	return "", fmt.Errorf("unknown tile format %q, expected png, jpeg or webp", s)
+}
+
+func (f TileFormat) ContentType() string {
+	return "image/" + string(f)
+}
+
+func (f TileFormat) Extension() string {
+	if f == JPEGTile {
+		return "jpg"
+	}
+	return string(f)
+}
+
+// Supported is whether tiles can be encoded in the format, as WebP needs
+// libvips (built with it)
+func (f TileFormat) Supported() bool {
+	switch f {
+	case PNGTile, JPEGTile:
+		return true
+	case WebPTile:
+		return webPSupported()
+	}
+	return false
+}
+
+// NegotiateTileFormat is preferred if the Accept header allows it (even by a
+// wildcard), otherwise the supported format the client would most like
+func NegotiateTileFormat(accept string, preferred TileFormat) TileFormat {
+	if strings.TrimSpace(accept) == "" {
+		return preferred
+	}
+
+	// The quality the client gives each format, from the most specific
+	// media range that matches it
+	quality := func(f TileFormat) float64 {
+		q, specificity := 0.0, -1
+		for _, part := range strings.Split(accept, ",") {
+			params := strings.Split(part, ";")
+			mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+			s := -1
+			switch mediaRange {
+			case f.ContentType():
+				s = 2
+			case "image/*":
+				s = 1
+			case "*/*":
+				s = 0
+			}
+			if s <= specificity {
+				continue
+			}
+			specificity, q = s, 1
+			for _, param := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+					if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+						q = v
+					}
+				}
+			}
+		}
+		return q
+	}
+
+	if quality(preferred) > 0 {
+		return preferred
+	}
+	best, bestQ := preferred, 0.0
+	for _, f := range tileFormats {
+		if q := quality(f); q > bestQ && f.Supported() {
+			best, bestQ = f, q
+		}
+	}
+	return best
+}
+
+// quality is the JPEG and WebP quality, defaultTileQuality unless one was set
+func (o TileOptions) quality() int {
+	if o.Quality <= 0 {
+		return defaultTileQuality
+	}
+	return o.Quality
+}
+
+// lossy is whether the format depends on the quality
+func (f TileFormat) lossy() bool {
+	return f == JPEGTile || f == WebPTile
+}
+
+// encodeTile encodes a tile in the format (the image's own when empty), JPEGs
+// flattened on to the nodata colour or white
+func (o TileOptions) encodeTile(img *image.RGBA, format TileFormat) (io.ReadSeeker, error) {
+	if format == "" {
+		format = o.format()
+	}
+	quality := o.quality()
+
+	w := bytes.Buffer{}
+	var err error
+	switch format {
+	case JPEGTile:
+		background := color.NRGBA{R: 255, G: 255, B: 255}
+		if _, _, _, a := o.noData().RGBA(); a != 0 {
+			background = color.NRGBAModel.Convert(o.noData()).(color.NRGBA)
+		}
+		background.A = 255
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), &image.Uniform{background}, image.ZP, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		err = jpeg.Encode(&w, flat, &jpeg.Options{Quality: quality})
+	case WebPTile:
+		var buf []byte
+		if buf, err = encodeWebP(img, quality); err == nil {
+			w.Write(buf)
+		}
+	default:
+		err = png.Encode(&w, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", format, err)
+	}
+	return bytes.NewReader(w.Bytes()), nil
+}
@@ -0,0 +1,104 @@
+package mapimage
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestParseTileFormat(t *testing.T) {
+	for s, expected := range map[string]TileFormat{
+		"":      PNGTile,
+		"png":   PNGTile,
+		".jpg":  JPEGTile,
+		"JPEG":  JPEGTile,
+		"webp":  WebPTile,
+		" png ": PNGTile,
+	} {
+		if result, err := ParseTileFormat(s); err != nil || result != expected {
+			t.Errorf("incorrect for %q, got: %v (%v), want: %v.", s, result, err, expected)
+		}
+	}
+	if _, err := ParseTileFormat("gif"); err == nil {
+		t.Error("expected an error for gif")
+	}
+}
+
+func TestNegotiateTileFormat(t *testing.T) {
+	for _, c := range []struct {
+		accept    string
+		preferred TileFormat
+		expected  TileFormat
+	}{
+		{"", JPEGTile, JPEGTile},
+		{"*/*", JPEGTile, JPEGTile},
+		// A browser, which takes anything
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", PNGTile, PNGTile},
+		{"image/png", JPEGTile, PNGTile},
+		{"image/jpeg;q=0.5, image/png", WebPTile, PNGTile},
+		{"image/png;q=0.5, image/jpeg", WebPTile, JPEGTile},
+		// Anything but JPEG
+		{"image/*, image/jpeg;q=0", JPEGTile, PNGTile},
+		// Nothing we have, so the image's own anyway
+		{"image/gif", JPEGTile, JPEGTile},
+	} {
+		if result := NegotiateTileFormat(c.accept, c.preferred); result != c.expected {
+			t.Errorf("incorrect for %q preferring %v, got: %v, want: %v.", c.accept, c.preferred, result, c.expected)
+		}
+	}
+}
+
+func TestEncodeTile(t *testing.T) {
+	// Half of the tile is image, the other half is nodata
+	img := TileOptions{}.emptyTile()
+	for y := 0; y < int(tileSize); y++ {
+		for x := 0; x < int(tileSize)/2; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+
+	for _, c := range []struct {
+		options  TileOptions
+		format   TileFormat
+		expected color.Color
+	}{
+		{TileOptions{}, "", color.Transparent},
+		{TileOptions{}, PNGTile, color.Transparent},
+		{TileOptions{Format: JPEGTile}, "", color.White},
+		{TileOptions{NoData: color.NRGBA{B: 255, A: 128}}, JPEGTile, color.NRGBA{B: 255, A: 255}},
+	} {
+		tile, err := c.options.encodeTile(img, c.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded image.Image
+		if c.format == JPEGTile || c.options.Format == JPEGTile {
+			decoded, err = jpeg.Decode(tile)
+		} else {
+			decoded, err = png.Decode(tile)
+		}
+		if err != nil {
+			t.Fatalf("incorrect for %v %v, not decoded: %v", c.options, c.format, err)
+		}
+
+		// (JPEG is lossy)
+		near := func(a, b color.Color) bool {
+			ar, ag, ab, aa := a.RGBA()
+			br, bg, bb, ba := b.RGBA()
+			for _, d := range []int{int(ar) - int(br), int(ag) - int(bg), int(ab) - int(bb), int(aa) - int(ba)} {
+				if d < -0x800 || d > 0x800 {
+					return false
+				}
+			}
+			return true
+		}
+		if result := decoded.At(250, 128); !near(result, c.expected) {
+			t.Errorf("incorrect nodata for %v %v, got: %v, want: %v.", c.options, c.format, result, c.expected)
+		}
+		if result := decoded.At(5, 128); !near(result, color.RGBA{R: 200, G: 100, B: 50, A: 255}) {
+			t.Errorf("incorrect image for %v %v, got: %v.", c.options, c.format, result)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package mapimage
+
+import (
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// TileOptions are the per image settings for how its map tiles are drawn
+type TileOptions struct {
+	// NoData fills the parts of a tile that are outside of the image (nil
+	// is transparent, so that maps can be layered on top of each other)
+	NoData color.Color
+	// Format is the tile format when a request doesn't ask for one (PNG if
+	// not set), and Quality the JPEG or WebP quality (1-100)
+	Format  TileFormat
+	Quality int
+	// Resampling is the kernel for each zoom (bilinear if not set)
+	Resampling Resampling
+	// OverviewDir is where the image's overviews are kept, the zoomed out
+	// tiles are drawn from the whole image when it is not set
+	OverviewDir string
+}
+
+// format is the image's tile format, PNG unless one was set
+func (o TileOptions) format() TileFormat {
+	if o.Format == "" {
+		return PNGTile
+	}
+	return o.Format
+}
+
+// noData is the fill colour, transparent unless one was set
+func (o TileOptions) noData() color.Color {
+	if o.NoData == nil {
+		return color.Transparent
+	}
+	return o.NoData
+}
+
+// noDataHex is the fill colour as hex, e.g. "ffffffff", to tell the tiles
+// cached with each apart
+func (o TileOptions) noDataHex() string {
+	c := color.NRGBAModel.Convert(o.noData()).(color.NRGBA)
+	return fmt.Sprintf("%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// emptyTile is a tile filled with the nodata colour, for the image to be
+// drawn on to (or to be used as is, when the tile is outside of the image)
+func (o TileOptions) emptyTile() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, int(tileSize), int(tileSize)))
+	draw.Draw(img, img.Bounds(), &image.Uniform{o.noData()}, image.ZP, draw.Src)
+	return img
+}
+
+// ParseColor reads "transparent", "black", "white", "#rgb", "#rrggbb" or
+// "#rrggbbaa", or "" as nil
+func ParseColor(s string) (color.Color, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return nil, nil
+	case "transparent", "none":
+		return color.Transparent, nil
+	case "black":
+		return color.Black, nil
+	case "white":
+		return color.White, nil
+	}
+
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return nil, fmt.Errorf("unknown colour %q, expected e.g. #rrggbb, #rrggbbaa or transparent", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
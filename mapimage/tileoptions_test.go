@@ -0,0 +1,107 @@
+package mapimage
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	for s, expected := range map[string]color.Color{
+		"":            nil,
+		"transparent": color.Transparent,
+		"White":       color.White,
+		"#f00":        color.NRGBA{R: 255, A: 255},
+		"#102030":     color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 255},
+		"#10203080":   color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0x80},
+	} {
+		result, err := ParseColor(s)
+		if err != nil || result != expected {
+			t.Errorf("incorrect for %q, got: %v (%v), want: %v.", s, result, err, expected)
+		}
+	}
+
+	for _, s := range []string{"#12345", "#1234567g", "red", "#-1234567"} {
+		if _, err := ParseColor(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+// cornerTile is the tile that the top left corner of the image is in
+func cornerTile(mi MapImage, zoom int64) (x, y int64) {
+	corner := mi.GeoFromPixel(LatLng{})
+	mx, my := LatLonToMeters(corner.Lat, corner.Lng)
+	size := Resolution(zoom) * float64(tileSize)
+	return int64(math.Floor((mx + originShift) / size)), int64(math.Floor((originShift - my) / size))
+}
+
+func decodeTile(t *testing.T, mi MapImage, zoom, x, y int64) image.Image {
+	img, err := png.Decode(mi.MapTile(zoom, x, y, PNGTile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestNoDataTiles(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	for _, noData := range []color.Color{nil, red} {
+		mi := testMapImage(t, TileOptions{NoData: noData})
+		expected := color.RGBAModel.Convert(TileOptions{NoData: noData}.noData())
+
+		// Partly outside of the image, the corner is inside it and the
+		// rest of the top left is not
+		zoom := int64(mi.MaxZoom())
+		x, y := cornerTile(mi, zoom)
+		tile := decodeTile(t, mi, zoom, x, y)
+		if c := color.RGBAModel.Convert(tile.At(0, 0)); c != expected {
+			t.Errorf("incorrect outside of the image for %v, got: %v, want: %v.", noData, c, expected)
+		}
+		if _, _, _, a := tile.At(255, 255).RGBA(); a != 0xffff {
+			t.Errorf("incorrect inside of the image for %v, got alpha: %v.", noData, a)
+		}
+
+		// Nowhere near the image, which the cache doesn't pass on
+		for _, sut := range []MapImage{mi, FilesystemCachedImage(mi)} {
+			tile := decodeTile(t, sut, zoom, x-10, y-10)
+			if c := color.RGBAModel.Convert(tile.At(128, 128)); c != expected {
+				t.Errorf("incorrect away from the image for %v, got: %v, want: %v.", noData, c, expected)
+			}
+		}
+	}
+}
+
+func TestTileCachePath(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	for _, c := range []struct {
+		options  TileOptions
+		expected string
+	}{
+		{TileOptions{}, "./media/map/bilinear/00000000/12/34/56.png"},
+		{TileOptions{NoData: red}, "./media/map/bilinear/ff0000ff/12/34/56.png"},
+		{TileOptions{NoData: color.White, Resampling: Resampling{{Kernel: LanczosKernel}}}, "./media/map/lanczos/ffffffff/12/34/56.png"},
+	} {
+		if _, result := tileCachePath("map", c.options, 12, 34, 56, PNGTile); result != c.expected {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", c.options, result, c.expected)
+		}
+	}
+
+	// The lossy formats are cached for each quality
+	for _, c := range []struct {
+		options  TileOptions
+		format   TileFormat
+		expected string
+	}{
+		{TileOptions{}, JPEGTile, "./media/map/bilinear/00000000/12/34/56.q80.jpg"},
+		{TileOptions{Quality: 95}, JPEGTile, "./media/map/bilinear/00000000/12/34/56.q95.jpg"},
+		{TileOptions{Quality: 50}, WebPTile, "./media/map/bilinear/00000000/12/34/56.q50.webp"},
+		{TileOptions{Quality: 50}, PNGTile, "./media/map/bilinear/00000000/12/34/56.png"},
+	} {
+		if _, result := tileCachePath("map", c.options, 12, 34, 56, c.format); result != c.expected {
+			t.Errorf("incorrect for %v %v, got: %v, want: %v.", c.format, c.options.Quality, result, c.expected)
+		}
+	}
+}
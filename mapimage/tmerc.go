@@ -0,0 +1,155 @@
+package mapimage
+
+import (
+	"math"
+)
+
+// TransverseMercatorTransformation projects lat/lng (in degrees) to a
+// Transverse Mercator grid, e.g. UTM, with Krüger's series to n^6
+type TransverseMercatorTransformation struct {
+	ellipsoid     Ellipsoid
+	lat0, lon0    float64
+	k0            float64
+	falseEasting  float64
+	falseNorthing float64
+	inverse       bool
+
+	// Derived from the above
+	a           float64 // the rectifying radius, times k0
+	alpha, beta [6]float64
+	xi0         float64 // xi at the latitude of origin
+}
+
+func NewTransverseMercatorTransformation(ellipsoid Ellipsoid, lat0, lon0, k0, falseEasting, falseNorthing float64) TransverseMercatorTransformation {
+	t := TransverseMercatorTransformation{
+		ellipsoid:     ellipsoid,
+		lat0:          lat0,
+		lon0:          lon0,
+		k0:            k0,
+		falseEasting:  falseEasting,
+		falseNorthing: falseNorthing,
+	}
+
+	n := ellipsoid.F() / (2 - ellipsoid.F())
+	n2, n3 := n*n, n*n*n
+	n4, n5, n6 := n3*n, n3*n2, n3*n3
+
+	t.a = k0 * ellipsoid.A / (1 + n) * (1 + n2/4 + n4/64 + n6/256)
+	t.alpha = [6]float64{
+		n/2 - 2*n2/3 + 5*n3/16 + 41*n4/180 - 127*n5/288 + 7891*n6/37800,
+		13*n2/48 - 3*n3/5 + 557*n4/1440 + 281*n5/630 - 1983433*n6/1935360,
+		61*n3/240 - 103*n4/140 + 15061*n5/26880 + 167603*n6/181440,
+		49561*n4/161280 - 179*n5/168 + 6601661*n6/7257600,
+		34729*n5/80640 - 3418889*n6/1995840,
+		212378941 * n6 / 319334400,
+	}
+	t.beta = [6]float64{
+		n/2 - 2*n2/3 + 37*n3/96 - n4/360 - 81*n5/512 + 96199*n6/604800,
+		n2/48 + n3/15 - 437*n4/1440 + 46*n5/105 - 1118711*n6/3870720,
+		17*n3/480 - 37*n4/840 - 209*n5/4480 + 5569*n6/90720,
+		4397*n4/161280 - 11*n5/504 - 830251*n6/7257600,
+		4583*n5/161280 - 108847*n6/3991680,
+		20648693 * n6 / 638668800,
+	}
+
+	t.xi0, _ = t.xiEta(RadFromDeg(lat0), 0)
+	return t
+}
+
+// NewUTMTransformation is the Universal Transverse Mercator zone (1 to 60) on
+// WGS84, in the northern or southern hemisphere
+func NewUTMTransformation(zone int, south bool) TransverseMercatorTransformation {
+	return NewUTMTransformationOn(WGS84, zone, south)
+}
+
+func NewUTMTransformationOn(ellipsoid Ellipsoid, zone int, south bool) TransverseMercatorTransformation {
+	falseNorthing := 0.0
+	if south {
+		falseNorthing = 10000000
+	}
+	return NewTransverseMercatorTransformation(ellipsoid, 0, float64(zone)*6-183, 0.9996, 500000, falseNorthing)
+}
+
+// xiEta is the position on the conformal sphere (phi and lambda in radians,
+// relative to the central meridian), before it is scaled up
+func (t *TransverseMercatorTransformation) xiEta(phi, lambda float64) (xi, eta float64) {
+	e := t.ellipsoid.E()
+	tau := math.Tan(phi)
+	sigma := math.Sinh(e * math.Atanh(e*tau/math.Sqrt(1+tau*tau)))
+	tauP := tau*math.Sqrt(1+sigma*sigma) - sigma*math.Sqrt(1+tau*tau)
+
+	xiP := math.Atan2(tauP, math.Cos(lambda))
+	etaP := math.Asinh(math.Sin(lambda) / math.Sqrt(tauP*tauP+math.Cos(lambda)*math.Cos(lambda)))
+
+	xi, eta = xiP, etaP
+	for j, alpha := range t.alpha {
+		k := 2 * float64(j+1)
+		xi += alpha * math.Sin(k*xiP) * math.Cosh(k*etaP)
+		eta += alpha * math.Cos(k*xiP) * math.Sinh(k*etaP)
+	}
+	return
+}
+
+func (t *TransverseMercatorTransformation) forward(lat, lng float64) (easting, northing float64) {
+	xi, eta := t.xiEta(RadFromDeg(lat), RadFromDeg(lng-t.lon0))
+	easting = t.falseEasting + t.a*eta
+	northing = t.falseNorthing + t.a*(xi-t.xi0)
+	return
+}
+
+func (t *TransverseMercatorTransformation) backward(easting, northing float64) (lat, lng float64) {
+	xi := (northing-t.falseNorthing)/t.a + t.xi0
+	eta := (easting - t.falseEasting) / t.a
+
+	xiP, etaP := xi, eta
+	for j, beta := range t.beta {
+		k := 2 * float64(j+1)
+		xiP -= beta * math.Sin(k*xi) * math.Cosh(k*eta)
+		etaP -= beta * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+
+	tauP := math.Sin(xiP) / math.Sqrt(math.Sinh(etaP)*math.Sinh(etaP)+math.Cos(xiP)*math.Cos(xiP))
+	lambda := math.Atan2(math.Sinh(etaP), math.Cos(xiP))
+
+	// Then back from the conformal latitude, with Newton's method
+	e := t.ellipsoid.E()
+	e2 := t.ellipsoid.E2()
+	tau := tauP
+	for i := 0; i < 10; i++ {
+		sigma := math.Sinh(e * math.Atanh(e*tau/math.Sqrt(1+tau*tau)))
+		tauI := tau*math.Sqrt(1+sigma*sigma) - sigma*math.Sqrt(1+tau*tau)
+		delta := (tauP - tauI) / math.Sqrt(1+tauI*tauI) *
+			(1 + (1-e2)*tau*tau) / ((1 - e2) * math.Sqrt(1+tau*tau))
+		tau += delta
+		if math.Abs(delta) < 1e-12 {
+			break
+		}
+	}
+
+	lat = DegFromRad(math.Atan(tau))
+	lng = t.lon0 + DegFromRad(lambda)
+	return
+}
+
+func (t *TransverseMercatorTransformation) Inverse() Transformation {
+	inverse := *t
+	inverse.inverse = !t.inverse
+	return &inverse
+}
+
+func (t *TransverseMercatorTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *TransverseMercatorTransformation) Projects(points ...Point) (results []Point) {
+	for _, p := range points {
+		if t.inverse {
+			lat, lng := t.backward(p.Lng, p.Lat)
+			results = append(results, Point{Lat: lat, Lng: lng})
+		} else {
+			easting, northing := t.forward(p.Lat, p.Lng)
+			results = append(results, PointEastingNorthing(easting, northing))
+		}
+	}
+	return
+}
@@ -0,0 +1,114 @@
+package mapimage
+
+import (
+	"errors"
+	"gonum.org/v1/gonum/mat"
+	"math"
+)
+
+// ThinPlateSplineTransformation is a rubber sheet through every control point:
+// f(x, y) = a0 + a1*x + a2*y + sum_i(w_i * U(|(x, y) - control_i|))
+type ThinPlateSplineTransformation struct {
+	offset   Point
+	scale    float64
+	controls []Point
+	// (N+3) x 2, i.e. w_1..w_N, a0, a1, a2 for each of easting and northing
+	weights *mat.Dense
+}
+
+func tpsKernel(a, b Point) float64 {
+	r2 := math.Pow(a.Lng-b.Lng, 2) + math.Pow(a.Lat-b.Lat, 2)
+	if r2 == 0 {
+		return 0
+	}
+	return r2 * math.Log(r2)
+}
+
+// Needs at least 3 points (that are not in a line), and will always fit all
+// of them exactly.
+func NewThinPlateSplineTransformationFromPoints(standardPoints []Point, localPoints []Point) (ThinPlateSplineTransformation, error) {
+	if len(standardPoints) != len(localPoints) {
+		return ThinPlateSplineTransformation{}, errors.New("tps: mismatched number of points")
+	}
+	if len(standardPoints) < 3 {
+		return ThinPlateSplineTransformation{}, errors.New("tps: at least 3 points are required")
+	}
+
+	t := ThinPlateSplineTransformation{}
+	t.offset, t.scale = normalisation(localPoints)
+	for _, p := range localPoints {
+		t.controls = append(t.controls, t.normalise(p))
+	}
+
+	//          L           *    W    =    V
+	//   ----------------       -----      -----
+	//  |  K   |    P    |     |  w  |    |  v  |
+	//  |------+---------|  *  |-----| =  |-----|
+	//  |  P^T |    0    |     |  a  |    |  0  |
+	//   ----------------       -----      -----
+	//
+	// Where K_ij = U(|control_i - control_j|), and each row of P is (1, x, y)
+	n := len(t.controls)
+	L := mat.NewDense(n+3, n+3, nil)
+	V := mat.NewDense(n+3, 2, nil)
+	for i, ci := range t.controls {
+		for j, cj := range t.controls {
+			L.Set(i, j, tpsKernel(ci, cj))
+		}
+		for j, v := range []float64{1, ci.Lng, ci.Lat} {
+			L.Set(i, n+j, v)
+			L.Set(n+j, i, v)
+		}
+		V.Set(i, 0, standardPoints[i].Lng)
+		V.Set(i, 1, standardPoints[i].Lat)
+	}
+
+	var weights mat.Dense
+	err := weights.Solve(L, V)
+	if err != nil {
+		return ThinPlateSplineTransformation{}, err
+	}
+	t.weights = &weights
+
+	return t, nil
+}
+
+func (t *ThinPlateSplineTransformation) normalise(p Point) Point {
+	return Point{
+		Lat: (p.Lat - t.offset.Lat) / t.scale,
+		Lng: (p.Lng - t.offset.Lng) / t.scale,
+	}
+}
+
+// Inverse has no closed form (and fitting another spline the other way would
+// not be the inverse between the points), so it is found iteratively
+func (t *ThinPlateSplineTransformation) Inverse() Transformation {
+	n := len(t.controls)
+	linear := linearPart(t.offset, t.scale,
+		t.weights.At(n, 0), t.weights.At(n+1, 0), t.weights.At(n+2, 0),
+		t.weights.At(n, 1), t.weights.At(n+1, 1), t.weights.At(n+2, 1))
+	inverse := NewIterativeInverseTransformation(t, linear.Inverse())
+	return &inverse
+}
+
+func (t *ThinPlateSplineTransformation) Project(p Point) Point {
+	return t.Projects(p)[0]
+}
+
+func (t *ThinPlateSplineTransformation) Projects(points ...Point) (results []Point) {
+	n := len(t.controls)
+	for _, p := range points {
+		p = t.normalise(p)
+
+		easting := t.weights.At(n, 0) + t.weights.At(n+1, 0)*p.Lng + t.weights.At(n+2, 0)*p.Lat
+		northing := t.weights.At(n, 1) + t.weights.At(n+1, 1)*p.Lng + t.weights.At(n+2, 1)*p.Lat
+		for i, c := range t.controls {
+			u := tpsKernel(p, c)
+			easting += t.weights.At(i, 0) * u
+			northing += t.weights.At(i, 1) * u
+		}
+
+		results = append(results, Point{Lat: northing, Lng: easting})
+	}
+	return
+}
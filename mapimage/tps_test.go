@@ -0,0 +1,80 @@
+package mapimage
+
+import (
+	"gonum.org/v1/gonum/floats"
+	"testing"
+)
+
+// Hand drawn maps: the control points are a bit all over the place
+var tpsTestPixels = []Point{
+	PointXY(120, 80), PointXY(5100, 300), PointXY(9800, 150),
+	PointXY(400, 4200), PointXY(4800, 5000), PointXY(10100, 4400),
+	PointXY(300, 9900), PointXY(5500, 9600), PointXY(9900, 10200),
+}
+var tpsTestGeo = []Point{
+	PointEastingNorthing(26.200, 40.300), PointEastingNorthing(26.251, 40.2985), PointEastingNorthing(26.2975, 40.301),
+	PointEastingNorthing(26.2035, 40.2584), PointEastingNorthing(26.2485, 40.2493), PointEastingNorthing(26.3004, 40.2562),
+	PointEastingNorthing(26.2021, 40.2001), PointEastingNorthing(26.2553, 40.2047), PointEastingNorthing(26.2992, 40.1978),
+}
+
+func TestThinPlateSplinePassesThroughControlPoints(t *testing.T) {
+	sut, err := NewThinPlateSplineTransformationFromPoints(tpsTestGeo, tpsTestPixels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, result := range sut.Projects(tpsTestPixels...) {
+		if !result.IsCloseTo(tpsTestGeo[i]) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", tpsTestPixels[i], result, tpsTestGeo[i])
+		}
+	}
+}
+
+func TestThinPlateSplineReproducesAffine(t *testing.T) {
+	// With no bending required, the spline is just the affine transformation
+	standard := make([]Point, len(tpsTestPixels))
+	for i, pt := range tpsTestPixels {
+		standard[i] = affineTestProject(pt)
+	}
+
+	sut, err := NewThinPlateSplineTransformationFromPoints(standard, tpsTestPixels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pt := range []Point{PointXY(0, 0), PointXY(2000, 7000), PointXY(12000, -500)} {
+		result := sut.Project(pt)
+		expect := affineTestProject(pt)
+		if !floats.EqualWithinAbs(result.Lat, expect.Lat, 1e-6) ||
+			!floats.EqualWithinAbs(result.Lng, expect.Lng, 1e-6) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", pt, result, expect)
+		}
+	}
+}
+
+func TestThinPlateSplineIterativeInverse(t *testing.T) {
+	toGeo, err := NewThinPlateSplineTransformationFromPoints(tpsTestGeo, tpsTestPixels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approximate, err := NewAffineTransformationFromPoints(tpsTestPixels, tpsTestGeo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toPixel := NewIterativeInverseTransformation(&toGeo, &approximate)
+
+	for i, result := range toPixel.Projects(tpsTestGeo...) {
+		if !floats.EqualWithinAbs(result.Lat, tpsTestPixels[i].Lat, 1e-4) ||
+			!floats.EqualWithinAbs(result.Lng, tpsTestPixels[i].Lng, 1e-4) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", tpsTestGeo[i], result, tpsTestPixels[i])
+		}
+	}
+
+	for _, pt := range []Point{PointXY(2500, 2500), PointXY(7777, 8888), PointXY(0, 10000)} {
+		result := toPixel.Project(toGeo.Project(pt))
+		if !floats.EqualWithinAbs(result.Lat, pt.Lat, 1e-4) ||
+			!floats.EqualWithinAbs(result.Lng, pt.Lng, 1e-4) {
+			t.Errorf("round trip incorrect for %v, got: %v.", pt, result)
+		}
+	}
+}
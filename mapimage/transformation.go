@@ -11,6 +11,106 @@ import (
 type Transformation interface {
 	Project(p Point) Point
 	Projects(points ...Point) (results []Point)
+	// Inverse projects the other way, i.e. Inverse().Project(Project(p)) is p
+	Inverse() Transformation
+}
+
+// The transformation models that can be chosen for a map image
+const (
+	AffineNoRotModel     = "affine-norot"
+	AffineModel          = "affine"
+	ConformalModel       = "conformal"
+	Polynomial2Model     = "polynomial-2"
+	Polynomial3Model     = "polynomial-3"
+	ThinPlateSplineModel = "tps"
+	PiecewiseAffineModel = "piecewise-affine"
+	ProjectiveModel      = "projective"
+)
+
+// NewTransformationFromReferencePoints fits the model (AffineNoRotModel if
+// empty) from pixels to WGS84 lat/lng, with the geographic points in crs
+func NewTransformationFromReferencePoints(model string, crs CRS, referencePoints []MapImagePair) (Transformation, error) {
+	geo, pixel := splitReferencePoints(referencePoints)
+	toLatLng := crs.toLatLng()
+
+	// The angles are only kept on the ground when fitted in a conformal
+	// projection, rather than in degrees of lat/lng
+	if model == ConformalModel && !crs.Projected() {
+		toMercator := NewWebMercatorTransformation()
+		geo = toMercator.Projects(crs.ToLatLng(geo...)...)
+		toLatLng = toMercator.Inverse()
+	}
+
+	fitted, err := fitTransformation(model, geo, pixel)
+	if err != nil || toLatLng == nil {
+		return fitted, err
+	}
+	chain := NewChainedTransformation(fitted, toLatLng)
+	return &chain, nil
+}
+
+// fitTransformation fits the named model to the points, as they are
+func fitTransformation(model string, geo, pixel []Point) (Transformation, error) {
+	switch model {
+	case "", AffineNoRotModel:
+		t, err := NewAffineNoRotTransformationFromPoints(geo, pixel)
+		return &t, err
+
+	case AffineModel:
+		t, err := NewAffineTransformationFromPoints(geo, pixel)
+		return &t, err
+
+	case ConformalModel:
+		// The pixels have to be flipped over first, as they count down from
+		// the top whereas northings count up (and a conformal transformation
+		// can't flip)
+		flip := NewAffineNoRotTransformation(1, -1, 0, 0)
+		t, err := NewConformalTransformationFromPoints(geo, flip.Projects(pixel...))
+		if err != nil {
+			return nil, err
+		}
+		chain := NewChainedTransformation(&flip, &t)
+		return &chain, nil
+
+	case Polynomial2Model, Polynomial3Model:
+		order := 2
+		if model == Polynomial3Model {
+			order = 3
+		}
+		t, err := NewPolynomialTransformationFromPoints(order, geo, pixel)
+		return &t, err
+
+	case ProjectiveModel:
+		t, err := NewProjectiveTransformationFromPoints(geo, pixel)
+		return &t, err
+
+	case ThinPlateSplineModel:
+		t, err := NewThinPlateSplineTransformationFromPoints(geo, pixel)
+		return &t, err
+
+	case PiecewiseAffineModel:
+		t, err := NewPiecewiseAffineTransformationFromPoints(geo, pixel)
+		return &t, err
+	}
+
+	return nil, fmt.Errorf("unknown transformation %q", model)
+}
+
+// PixelResiduals reports how far (in pixels) toPixel misses each of the
+// reference points it was fitted from
+func PixelResiduals(toPixel Transformation, referencePoints []MapImagePair) Residuals {
+	geo, pixel := splitReferencePoints(referencePoints)
+	return NewResiduals(toPixel, pixel, geo)
+}
+
+func splitReferencePoints(referencePoints []MapImagePair) (geo, pixel []Point) {
+	geo = make([]Point, len(referencePoints))
+	pixel = make([]Point, len(referencePoints))
+	for i, rp := range referencePoints {
+		geo[i] = rp.Geographic.toPoint()
+		pixel[i] = rp.Pixel.toPoint()
+	}
+	return
 }
 
 type Point struct {
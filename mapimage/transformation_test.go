@@ -0,0 +1,130 @@
+package mapimage
+
+import (
+	"gonum.org/v1/gonum/floats"
+	"testing"
+)
+
+// The hand drawn map, plus a few more points so there are enough for a cubic
+func transformationTestReferencePoints() []MapImagePair {
+	pixels := append(append([]Point{}, tpsTestPixels...),
+		PointXY(2600, 2300), PointXY(7600, 7400), PointXY(2400, 7700))
+	geo := append(append([]Point{}, tpsTestGeo...),
+		PointEastingNorthing(26.2249, 40.2792), PointEastingNorthing(26.2765, 40.2236), PointEastingNorthing(26.2258, 40.2207))
+
+	var referencePoints []MapImagePair
+	for i := range pixels {
+		referencePoints = append(referencePoints, MapImagePair{
+			Geographic: LatLng(geo[i]),
+			Pixel:      LatLng(pixels[i]),
+		})
+	}
+	return referencePoints
+}
+
+var transformationTestModels = []string{
+	AffineNoRotModel,
+	AffineModel,
+	ConformalModel,
+	Polynomial2Model,
+	Polynomial3Model,
+	ThinPlateSplineModel,
+	PiecewiseAffineModel,
+	ProjectiveModel,
+}
+
+func TestInverseRoundTrip(t *testing.T) {
+	referencePoints := transformationTestReferencePoints()
+	pixels := []Point{
+		PointXY(0, 0), PointXY(10240, 0), PointXY(0, 10240), PointXY(10240, 10240),
+		PointXY(5000, 5000), PointXY(1234, 8765), PointXY(8080, 3030),
+	}
+
+	for _, model := range transformationTestModels {
+		sut, err := NewTransformationFromReferencePoints(model, LatLngCRS, referencePoints)
+		if err != nil {
+			t.Errorf("%v: %v", model, err)
+			continue
+		}
+		inverse := sut.Inverse()
+
+		for _, pt := range pixels {
+			result := inverse.Project(sut.Project(pt))
+			if !floats.EqualWithinAbs(result.Lat, pt.Lat, 1e-6) ||
+				!floats.EqualWithinAbs(result.Lng, pt.Lng, 1e-6) {
+				t.Errorf("%v: round trip incorrect for %v, got: %v.", model, pt, result)
+			}
+
+			geo := sut.Project(pt)
+			result = sut.Project(inverse.Project(geo))
+			if !floats.EqualWithinAbs(result.Lat, geo.Lat, 1e-9) ||
+				!floats.EqualWithinAbs(result.Lng, geo.Lng, 1e-9) {
+				t.Errorf("%v: round trip incorrect for %v, got: %v.", model, geo, result)
+			}
+		}
+
+		// And inverting twice gets back to the same thing
+		for _, pt := range pixels {
+			result := inverse.Inverse().Project(pt)
+			expect := sut.Project(pt)
+			if !floats.EqualWithinAbs(result.Lat, expect.Lat, 1e-9) ||
+				!floats.EqualWithinAbs(result.Lng, expect.Lng, 1e-9) {
+				t.Errorf("%v: incorrect for %v, got: %v, want: %v.", model, pt, result, expect)
+			}
+		}
+	}
+}
+
+func TestGeoreferenceUsesInverse(t *testing.T) {
+	referencePoints := transformationTestReferencePoints()
+
+	for _, model := range transformationTestModels {
+		sut, err := NewGeoreference(model, LatLngCRS, referencePoints, 0)
+		if err != nil {
+			t.Errorf("%v: %v", model, err)
+			continue
+		}
+		for _, rp := range referencePoints {
+			result := sut.PixelFromGeo(sut.GeoFromPixel(rp.Pixel))
+			if !LatLng(result).toPoint().IsCloseTo(rp.Pixel.toPoint()) {
+				t.Errorf("%v: round trip incorrect for %v, got: %v.", model, rp.Pixel, result)
+			}
+		}
+	}
+}
+
+func TestUnknownModel(t *testing.T) {
+	if _, err := NewGeoreference("bendy", LatLngCRS, transformationTestReferencePoints(), 0); err == nil {
+		t.Errorf("expected an error for an unknown transformation")
+	}
+}
+
+func TestAffineFitsUnflipped(t *testing.T) {
+	// Only the conformal fit flips the pixels over, affine and affine-norot
+	// are fitted to them as they are, just as they always have been
+	referencePoints := transformationTestReferencePoints()
+	geo, pixel := splitReferencePoints(referencePoints)
+	norot, _ := NewAffineNoRotTransformationFromPoints(geo, pixel)
+	affine, _ := NewAffineTransformationFromPoints(geo, pixel)
+
+	for model, expected := range map[string]Transformation{AffineNoRotModel: &norot, AffineModel: &affine} {
+		sut, err := NewTransformationFromReferencePoints(model, LatLngCRS, referencePoints)
+		if err != nil {
+			t.Fatalf("%v: %v", model, err)
+		}
+		for _, pt := range []Point{PointXY(0, 0), PointXY(10240, 0), PointXY(0, 10240), PointXY(5000, 5000)} {
+			result, want := sut.Project(pt), expected.Project(pt)
+			if !floats.EqualWithinAbs(result.Lat, want.Lat, 1e-12) || !floats.EqualWithinAbs(result.Lng, want.Lng, 1e-12) {
+				t.Errorf("%v: incorrect for %v, got: %v, want: %v.", model, pt, result, want)
+			}
+		}
+	}
+
+	// Whichever the model, down the image (y increasing) is south
+	for _, model := range []string{AffineNoRotModel, AffineModel, ConformalModel} {
+		sut, _ := NewTransformationFromReferencePoints(model, LatLngCRS, referencePoints)
+		if top, bottom := sut.Project(PointXY(5000, 0)), sut.Project(PointXY(5000, 10000)); bottom.Lat >= top.Lat {
+			t.Errorf("%v: incorrect, got: %v at the top and %v at the bottom.", model, top, bottom)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+//go:build vips && cgo
+// +build vips,cgo
+
+package mapimage
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// save_png saves the image as a PNG (quickly, as it's only read back the
+// once) and unrefs it
+static int save_png(VipsImage *image, const char *out) {
+	int err = vips_pngsave(image, out, "compression", 1, NULL);
+	g_object_unref(image);
+	return err;
+}
+
+static int file_to_png(const char *in, const char *out) {
+	VipsImage *image = vips_image_new_from_file(in, "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+	if (image == NULL) {
+		return -1;
+	}
+	return save_png(image, out);
+}
+
+static int shrink_to_png(const char *in, const char *out, int width, int height) {
+	VipsImage *image;
+	if (vips_thumbnail(in, &image, width, "height", height, "size", VIPS_SIZE_FORCE, NULL)) {
+		return -1;
+	}
+	return save_png(image, out);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"strings"
+	"unsafe"
+)
+
+// vipsFileToPNG has libvips convert the image file in to a PNG file out. It
+// is read from the top down, so the pixels are never all in memory.
+func vipsFileToPNG(in, out string) error {
+	cin, cout := C.CString(in), C.CString(out)
+	defer C.free(unsafe.Pointer(cin))
+	defer C.free(unsafe.Pointer(cout))
+	if C.file_to_png(cin, cout) != 0 {
+		return vipsError()
+	}
+	return nil
+}
+
+// vipsShrinkToPNG has libvips shrink the image file in to width x height, as
+// a PNG file out. A JPEG is shrunk as it is loaded.
+func vipsShrinkToPNG(in, out string, width, height int) error {
+	cin, cout := C.CString(in), C.CString(out)
+	defer C.free(unsafe.Pointer(cin))
+	defer C.free(unsafe.Pointer(cout))
+	if C.shrink_to_png(cin, cout, C.int(width), C.int(height)) != 0 {
+		return vipsError()
+	}
+	return nil
+}
+
+func vipsError() error {
+	msg := strings.TrimSpace(C.GoString(C.vips_error_buffer()))
+	C.vips_error_clear()
+	return errors.New("vips: " + msg)
+}
@@ -0,0 +1,106 @@
+package mapimage
+
+import (
+	"encoding/xml"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+)
+
+// The parts of a GDAL VRT that we need, to give an image GCPs
+type vrtDataset struct {
+	XMLName xml.Name  `xml:"VRTDataset"`
+	Width   int       `xml:"rasterXSize,attr"`
+	Height  int       `xml:"rasterYSize,attr"`
+	GCPs    vrtGCPs   `xml:"GCPList"`
+	Bands   []vrtBand `xml:"VRTRasterBand"`
+}
+
+type vrtGCPs struct {
+	Projection string   `xml:"Projection,attr"`
+	GCPs       []vrtGCP `xml:"GCP"`
+}
+
+type vrtGCP struct {
+	Id    string  `xml:"Id,attr"`
+	Pixel float64 `xml:"Pixel,attr"`
+	Line  float64 `xml:"Line,attr"`
+	X     float64 `xml:"X,attr"`
+	Y     float64 `xml:"Y,attr"`
+}
+
+type vrtBand struct {
+	DataType    string    `xml:"dataType,attr"`
+	Band        int       `xml:"band,attr"`
+	ColorInterp string    `xml:"ColorInterp"`
+	Source      vrtSource `xml:"SimpleSource"`
+}
+
+type vrtSource struct {
+	Filename   vrtFilename `xml:"SourceFilename"`
+	SourceBand int         `xml:"SourceBand"`
+}
+
+type vrtFilename struct {
+	RelativeToVRT int    `xml:"relativeToVRT,attr"`
+	Name          string `xml:",chardata"`
+}
+
+// WriteVRT writes a GDAL VRT giving the image file at source the reference
+// points (without the outliers) as GCPs
+func WriteVRT(w io.Writer, i MapImage, source string) error {
+	content := i.ImageContent()
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	config, _, err := image.DecodeConfig(content)
+	if err != nil {
+		return err
+	}
+
+	vrt := vrtDataset{
+		Width:  config.Width,
+		Height: config.Height,
+		GCPs:   vrtGCPs{Projection: wgs84WKT},
+	}
+	for n, rp := range exportReferencePoints(i) {
+		vrt.GCPs.GCPs = append(vrt.GCPs.GCPs, vrtGCP{
+			Id:    strconv.Itoa(n + 1),
+			Pixel: rp.Pixel.Lng,
+			Line:  rp.Pixel.Lat,
+			X:     rp.Geographic.Lng,
+			Y:     rp.Geographic.Lat,
+		})
+	}
+
+	colours := []string{"Red", "Green", "Blue"}
+	switch config.ColorModel {
+	case color.GrayModel, color.Gray16Model:
+		colours = []string{"Gray"}
+	case color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model:
+		colours = append(colours, "Alpha")
+	}
+	for n, colour := range colours {
+		vrt.Bands = append(vrt.Bands, vrtBand{
+			DataType:    "Byte",
+			Band:        n + 1,
+			ColorInterp: colour,
+			Source: vrtSource{
+				Filename:   vrtFilename{Name: source},
+				SourceBand: n + 1,
+			},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(vrt); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,176 @@
+package mapimage
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// tileLatLng is the geographic position of (px, py) within tile (x, y), where
+// (0, 0) is the top left corner of the tile and (256, 256) the bottom right.
+func tileLatLng(zoom, x, y int64, px, py float64) LatLng {
+	res := Resolution(zoom)
+	mx := (float64(x*tileSize)+px)*res - originShift
+	my := originShift - (float64(y*tileSize)+py)*res
+
+	lat, lng := webMercatorToLatLon(mx, my)
+	return LatLng{Lat: lat, Lng: lng}
+}
+
+// tileFootprint is the bounding box (in source image pixels) of the tile's edges
+func tileFootprint(zoom, x, y int64, pixelFromGeo func(LatLng) LatLng) image.Rectangle {
+	const steps = 8
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+
+	for i := 0; i <= steps; i++ {
+		t := float64(tileSize) * float64(i) / steps
+		for _, edge := range [][2]float64{{t, 0}, {t, float64(tileSize)}, {0, t}, {float64(tileSize), t}} {
+			p := pixelFromGeo(tileLatLng(zoom, x, y, edge[0], edge[1]))
+			minX, maxX = math.Min(minX, p.Lng), math.Max(maxX, p.Lng)
+			minY, maxY = math.Min(minY, p.Lat), math.Max(maxY, p.Lat)
+		}
+	}
+
+	return image.Rect(
+		int(math.Floor(minX)), int(math.Floor(minY)),
+		int(math.Ceil(maxX)), int(math.Ceil(maxY)),
+	)
+}
+
+const (
+	// warpGrid is the spacing (in tile pixels) of the grid that is projected
+	// exactly, the pixels in between are interpolated from it...
+	warpGrid = 16
+	// ...unless that would be more than warpTolerance pixels out (of the
+	// source image, or of the tile when it is zoomed out and they are bigger)
+	warpTolerance = 0.125
+)
+
+// tileSources is where the centre of each pixel of the tile (in rows) comes
+// from in the source, interpolated across a grid where that is close enough
+func tileSources(zoom, x, y int64, toSource func(LatLng) LatLng) []LatLng {
+	const n = int(tileSize) / warpGrid
+	project := func(px, py float64) LatLng {
+		return toSource(tileLatLng(zoom, x, y, px, py))
+	}
+
+	var grid [n + 1][n + 1]LatLng
+	for j := 0; j <= n; j++ {
+		for i := 0; i <= n; i++ {
+			grid[j][i] = project(float64(i*warpGrid), float64(j*warpGrid))
+		}
+	}
+
+	sources := make([]LatLng, tileSize*tileSize)
+	for cj := 0; cj < n; cj++ {
+		for ci := 0; ci < n; ci++ {
+			c00, c10 := grid[cj][ci], grid[cj][ci+1]
+			c01, c11 := grid[cj+1][ci], grid[cj+1][ci+1]
+			interpolate := func(fx, fy float64) LatLng {
+				return LatLng{
+					Lat: (1-fy)*((1-fx)*c00.Lat+fx*c10.Lat) + fy*((1-fx)*c01.Lat+fx*c11.Lat),
+					Lng: (1-fy)*((1-fx)*c00.Lng+fx*c10.Lng) + fy*((1-fx)*c01.Lng+fx*c11.Lng),
+				}
+			}
+
+			sourcePerTile := math.Hypot(c10.Lng-c00.Lng, c10.Lat-c00.Lat) / warpGrid
+			tolerance := warpTolerance * math.Max(1, sourcePerTile)
+			exact := false
+			for _, f := range [][2]float64{{0.5, 0.5}, {0.25, 0.25}, {0.75, 0.25}, {0.25, 0.75}, {0.75, 0.75}} {
+				p := project((float64(ci)+f[0])*warpGrid, (float64(cj)+f[1])*warpGrid)
+				guess := interpolate(f[0], f[1])
+				if !(math.Hypot(p.Lng-guess.Lng, p.Lat-guess.Lat) <= tolerance) {
+					exact = true
+					break
+				}
+			}
+
+			for py := 0; py < warpGrid; py++ {
+				for px := 0; px < warpGrid; px++ {
+					tx, ty := ci*warpGrid+px, cj*warpGrid+py
+					var s LatLng
+					if exact {
+						s = project(float64(tx)+0.5, float64(ty)+0.5)
+					} else {
+						s = interpolate((float64(px)+0.5)/warpGrid, (float64(py)+0.5)/warpGrid)
+					}
+					sources[ty*int(tileSize)+tx] = s
+				}
+			}
+		}
+	}
+	return sources
+}
+
+// warpTile fills in each pixel of the tile from where toSource puts it in src
+// (those outside of src are left alone)
+func warpTile(dst *image.RGBA, src image.Image, zoom, x, y int64, toSource func(LatLng) LatLng, kernel Kernel) {
+	bounds := src.Bounds()
+	sources := tileSources(zoom, x, y, toSource)
+	n := int(tileSize)
+	distance := func(i, j int) float64 {
+		return math.Hypot(sources[i].Lng-sources[j].Lng, sources[i].Lat-sources[j].Lat)
+	}
+	for i, s := range sources {
+		if !(s.Lng >= float64(bounds.Min.X) && s.Lng < float64(bounds.Max.X) &&
+			s.Lat >= float64(bounds.Min.Y) && s.Lat < float64(bounds.Max.Y)) {
+			continue
+		}
+		px, py := i%n, i/n
+
+		var scaleX, scaleY float64
+		if px+1 < n {
+			scaleX = distance(i, i+1)
+		} else {
+			scaleX = distance(i, i-1)
+		}
+		if py+1 < n {
+			scaleY = distance(i, i+n)
+		} else {
+			scaleY = distance(i, i-n)
+		}
+		dst.SetRGBA(dst.Rect.Min.X+px, dst.Rect.Min.Y+py, kernel.sample(src, s.Lng, s.Lat, scaleX, scaleY))
+	}
+}
+
+// bilinear samples src at the (fractional) position u, v; where pixel (i, j)
+// covers the area from (i, j) to (i+1, j+1).
+func bilinear(src image.Image, u, v float64) color.RGBA {
+	bounds := src.Bounds()
+	fx, fy := u-0.5, v-0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	wx, wy := fx-float64(x0), fy-float64(y0)
+
+	clamp := func(i, lo, hi int) int {
+		return max(lo, min(i, hi-1))
+	}
+	x1 := clamp(x0+1, bounds.Min.X, bounds.Max.X)
+	y1 := clamp(y0+1, bounds.Min.Y, bounds.Max.Y)
+	x0 = clamp(x0, bounds.Min.X, bounds.Max.X)
+	y0 = clamp(y0, bounds.Min.Y, bounds.Max.Y)
+
+	var r, g, b, a float64
+	for _, s := range []struct {
+		x, y int
+		w    float64
+	}{
+		{x0, y0, (1 - wx) * (1 - wy)},
+		{x1, y0, wx * (1 - wy)},
+		{x0, y1, (1 - wx) * wy},
+		{x1, y1, wx * wy},
+	} {
+		sr, sg, sb, sa := src.At(s.x, s.y).RGBA()
+		r += float64(sr) * s.w
+		g += float64(sg) * s.w
+		b += float64(sb) * s.w
+		a += float64(sa) * s.w
+	}
+
+	return color.RGBA{
+		R: uint8(uint32(r) >> 8),
+		G: uint8(uint32(g) >> 8),
+		B: uint8(uint32(b) >> 8),
+		A: uint8(uint32(a) >> 8),
+	}
+}
@@ -0,0 +1,68 @@
+package mapimage
+
+import (
+	"math"
+	"testing"
+)
+
+func warpTestGeoreference(t *testing.T, model string) Georeference {
+	georef, err := NewGeoreference(model, LatLngCRS, transformationTestReferencePoints(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return georef
+}
+
+func TestTileSourcesAreSubPixel(t *testing.T) {
+	// Zoomed in (where a tile is a few hundred source pixels) and out (where a
+	// tile covers the whole image)
+	tiles := [][3]int64{{16, 37546, 24751}, {16, 37540, 24745}, {13, 4693, 3093}, {11, 1173, 773}}
+
+	for _, model := range []string{AffineNoRotModel, ConformalModel, AffineModel, ThinPlateSplineModel} {
+		georef := warpTestGeoreference(t, model)
+		for _, tile := range tiles {
+			zoom, x, y := tile[0], tile[1], tile[2]
+			// Less than half a pixel, of the source image or of the tile when
+			// it is zoomed out (warpTolerance is only checked at a few points)
+			footprint := tileFootprint(zoom, x, y, georef.PixelFromGeo)
+			tolerance := 0.5 * math.Max(1, float64(footprint.Dx())/float64(tileSize))
+
+			worst := 0.0
+			for i, s := range tileSources(zoom, x, y, georef.PixelFromGeo) {
+				px, py := float64(i%int(tileSize))+0.5, float64(i/int(tileSize))+0.5
+				exact := georef.PixelFromGeo(tileLatLng(zoom, x, y, px, py))
+				worst = math.Max(worst, math.Hypot(s.Lng-exact.Lng, s.Lat-exact.Lat))
+			}
+			if worst > tolerance {
+				t.Errorf("%v: incorrect for tile %v, got: %v pixels out, want: <= %v.", model, tile, worst, tolerance)
+			}
+		}
+	}
+}
+
+func TestTileSourcesHaveNoSeams(t *testing.T) {
+	georef := warpTestGeoreference(t, ThinPlateSplineModel)
+	zoom, x, y := int64(14), int64(9386), int64(6187)
+
+	here := tileSources(zoom, x, y, georef.PixelFromGeo)
+	right := tileSources(zoom, x+1, y, georef.PixelFromGeo)
+	below := tileSources(zoom, x, y+1, georef.PixelFromGeo)
+
+	// The step across the edge to the next tile should be the same as the
+	// step between the pixels either side of it
+	n := int(tileSize)
+	for j := 0; j < n; j++ {
+		inside := here[j*n+n-1].Lng - here[j*n+n-2].Lng
+		across := right[j*n].Lng - here[j*n+n-1].Lng
+		if math.Abs(across-inside) > 2*warpTolerance {
+			t.Errorf("seam on the right at row %v, got: %v, want: %v.", j, across, inside)
+		}
+	}
+	for i := 0; i < n; i++ {
+		inside := here[(n-1)*n+i].Lat - here[(n-2)*n+i].Lat
+		across := below[i].Lat - here[(n-1)*n+i].Lat
+		if math.Abs(across-inside) > 2*warpTolerance {
+			t.Errorf("seam below at column %v, got: %v, want: %v.", i, across, inside)
+		}
+	}
+}
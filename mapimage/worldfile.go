@@ -0,0 +1,135 @@
+package mapimage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WorldFile is an ESRI world file, with col and row at the pixel's centre:
+//
+//	x = A*col + B*row + C
+//	y = D*col + E*row + F
+type WorldFile struct {
+	A, D, B, E, C, F float64
+}
+
+// worldFileSamples is how many points along each side of the image are used
+// when fitting a world file to a georeference that is not affine
+const worldFileSamples = 8
+
+// ReadWorldFile parses the six lines of a world file, which are in the order
+// A, D, B, E, C, F
+func ReadWorldFile(r io.Reader) (WorldFile, error) {
+	var values []float64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if len(values) == 6 {
+			return WorldFile{}, fmt.Errorf("worldfile: more than six values")
+		}
+		v, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return WorldFile{}, fmt.Errorf("worldfile: bad value %q", line)
+		}
+		values = append(values, v)
+	}
+	if err := scanner.Err(); err != nil {
+		return WorldFile{}, err
+	}
+	if len(values) != 6 {
+		return WorldFile{}, fmt.Errorf("worldfile: needs six values, not %v", len(values))
+	}
+
+	w := WorldFile{A: values[0], D: values[1], B: values[2], E: values[3], C: values[4], F: values[5]}
+	if w.A*w.E-w.B*w.D == 0 {
+		return WorldFile{}, fmt.Errorf("worldfile: the pixel size is zero")
+	}
+	return w, nil
+}
+
+// FindWorldFile looks for map.jgw, map.jpgw or map.wld next to map.jpg
+func FindWorldFile(filename string) (string, bool) {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	ext = strings.TrimPrefix(ext, ".")
+
+	var candidates []string
+	if len(ext) >= 2 {
+		candidates = append(candidates, ext[:1]+ext[len(ext)-1:]+"w")
+	}
+	if ext != "" {
+		candidates = append(candidates, ext+"w")
+	}
+	candidates = append(candidates, "wld")
+
+	for _, c := range candidates {
+		for _, name := range []string{base + "." + strings.ToLower(c), base + "." + strings.ToUpper(c)} {
+			if info, err := os.Stat(name); err == nil && !info.IsDir() {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Transformation is the world file as a transformation from pixels (with
+// (0, 0) at the corner of the image) to the CRS
+func (w WorldFile) Transformation() AffineTransformation {
+	return NewAffineTransformation(
+		w.A, w.B,
+		w.D, w.E,
+		w.C-(w.A+w.B)/2,
+		w.F-(w.D+w.E)/2,
+	)
+}
+
+// Write writes the world file out in its usual six line form
+func (w WorldFile) Write(out io.Writer) error {
+	for _, v := range []float64{w.A, w.D, w.B, w.E, w.C, w.F} {
+		if _, err := fmt.Fprintf(out, "%s\n", strconv.FormatFloat(v, 'f', -1, 64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewWorldFile is the affine that best fits the georeference, in its CRS
+func NewWorldFile(g Georeference, pixelBounds [2]LatLng) (WorldFile, error) {
+	return fitWorldFile(g, g.crs, pixelBounds)
+}
+
+// fitWorldFile is the world file for a georeference, in crs
+func fitWorldFile(g Georeference, crs CRS, pixelBounds [2]LatLng) (WorldFile, error) {
+	minPx, maxPx := pixelBounds[0], pixelBounds[1]
+
+	var pixels []Point
+	for i := 0; i <= worldFileSamples; i++ {
+		for j := 0; j <= worldFileSamples; j++ {
+			pixels = append(pixels, Point{
+				Lat: minPx.Lat + (maxPx.Lat-minPx.Lat)*float64(i)/worldFileSamples,
+				Lng: minPx.Lng + (maxPx.Lng-minPx.Lng)*float64(j)/worldFileSamples,
+			})
+		}
+	}
+	geo := crs.FromLatLng(g.toGeo.Projects(pixels...)...)
+
+	fitted, err := NewAffineTransformationFromPoints(geo, pixels)
+	if err != nil {
+		return WorldFile{}, err
+	}
+
+	a, b, c, d := fitted.trans.At(0, 0), fitted.trans.At(1, 0), fitted.trans.At(2, 0), fitted.trans.At(3, 0)
+	Tx, Ty := fitted.trans.At(4, 0), fitted.trans.At(5, 0)
+	return WorldFile{
+		A: a, B: b, C: Tx + (a+b)/2,
+		D: c, E: d, F: Ty + (c+d)/2,
+	}, nil
+}
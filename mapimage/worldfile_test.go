@@ -0,0 +1,134 @@
+package mapimage
+
+import (
+	"bytes"
+	"gonum.org/v1/gonum/floats"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadWorldFile(t *testing.T) {
+	// A 2m UTM image, with its top left pixel centred on 453121, 4455799
+	sut, err := ReadWorldFile(strings.NewReader("2.0\n0.0\n0.0\n-2.0\n453121.0\n4455799.0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	toCRS := sut.Transformation()
+
+	tests := []struct {
+		pixel, expected Point
+	}{
+		{PointXY(0, 0), PointEastingNorthing(453120, 4455800)},
+		{PointXY(0.5, 0.5), PointEastingNorthing(453121, 4455799)},
+		{PointXY(100, 50), PointEastingNorthing(453320, 4455700)},
+	}
+	for _, test := range tests {
+		result := toCRS.Project(test.pixel)
+		if !result.IsCloseTo(test.expected) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", test.pixel, result, test.expected)
+		}
+	}
+}
+
+func TestBadWorldFiles(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"1\n0\n0\n-1\n100\n",
+		"1\n0\n0\n-1\n100\n200\n300\n",
+		"1\n0\n0\n-1\n100\nNorth\n",
+		"0\n0\n0\n0\n100\n200\n",
+	} {
+		if _, err := ReadWorldFile(strings.NewReader(content)); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
+
+func TestWorldFileRoundTrip(t *testing.T) {
+	crs, err := ParseCRS("EPSG:32635")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf := WorldFile{A: 1.5, D: 0.25, B: 0.3, E: -1.5, C: 453121, F: 4455799}
+	toCRS := wf.Transformation()
+	georef := NewGeoreferenceFromTransformation(AffineModel, crs, &toCRS)
+
+	result, err := NewWorldFile(georef, [2]LatLng{{Lat: 0, Lng: 0}, {Lat: 4000, Lng: 3000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, pair := range [][2]float64{{result.A, wf.A}, {result.B, wf.B}, {result.C, wf.C}, {result.D, wf.D}, {result.E, wf.E}, {result.F, wf.F}} {
+		if !floats.EqualWithinAbs(pair[0], pair[1], 1e-6) {
+			t.Errorf("incorrect parameter %v, got: %v, want: %v.", i, pair[0], pair[1])
+		}
+	}
+
+	var out bytes.Buffer
+	if err := result.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 6 {
+		t.Errorf("incorrect, got: %q.", out.String())
+	}
+	read, err := ReadWorldFile(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != result {
+		t.Errorf("incorrect, got: %v, want: %v.", read, result)
+	}
+}
+
+func TestWorldFileForNonAffine(t *testing.T) {
+	// The best fit of an affine to a Web Mercator image in degrees
+	georef, err := NewGeoreference(ConformalModel, LatLngCRS, transformationTestReferencePoints(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := [2]LatLng{{Lat: 0, Lng: 0}, {Lat: 10000, Lng: 10000}}
+	wf, err := NewWorldFile(georef, bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toCRS := wf.Transformation()
+	for _, pixel := range []LatLng{bounds[0], {Lat: 5000, Lng: 5000}, bounds[1]} {
+		result := LatLng(toCRS.Project(pixel.toPoint()))
+		expect := georef.GeoFromPixel(pixel)
+		if d := distanceMetres(result, expect); d > 50 {
+			t.Errorf("incorrect for %v, got: %v, want: %v (%.1fm).", pixel, result, expect, d)
+		}
+	}
+}
+
+func TestFindWorldFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worldfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.JGW", "b.tifw", "c.wld", "d.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		image, expected string
+	}{
+		{"a.jpg", "a.JGW"},
+		{"b.tif", "b.tifw"},
+		{"c.png", "c.wld"},
+		{"d.png", ""},
+	}
+	for _, test := range tests {
+		result, ok := FindWorldFile(filepath.Join(dir, test.image))
+		if filepath.Base(result) != test.expected && !(test.expected == "" && !ok) {
+			t.Errorf("incorrect for %v, got: %v, want: %v.", test.image, result, test.expected)
+		}
+	}
+}
@@ -3,31 +3,79 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/ghodss/yaml"
 	"github.com/gorilla/mux"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"io/ioutil"
 	"log"
 	"main/mapimage"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 type db struct {
 	images []mapimage.MapImage
+	// The image file for each id
+	filenames map[string]string
 }
 
 var maps db
 
 type ImageConfig struct {
-	Id              string                  `json:"id"`
-	Name            string                  `json:"name"`
+	Id             string `json:"id"`
+	Name           string `json:"name"`
+	Transformation string `json:"transformation"`
+	// The coordinate reference system of the referencePoints (lat/lng if not set)
+	CRS string `json:"crs"`
+	// The datum of the CRS, when it is not (near enough) WGS84
+	Datum           *mapimage.DatumConfig   `json:"datum"`
 	ReferencePoints []mapimage.MapImagePair `json:"referencePoints"`
-	Filename        string                  `json:"filename"`
+	// An OziExplorer .map or QGIS .points file (in ./images) to use instead of
+	// the referencePoints
+	Calibration string `json:"calibration"`
+	// Pixels, when set the reference points are checked for outliers
+	OutlierThreshold float64 `json:"outlierThreshold"`
+	Filename         string  `json:"filename"`
+	// The colour of the tiles outside of the image (transparent if not set)
+	NoData string `json:"nodata"`
+	// png (the default), jpeg or webp, for the tiles requested without one
+	TileFormat string `json:"tileFormat"`
+	// The JPEG or WebP quality, 1-100
+	TileQuality int `json:"tileQuality"`
+	// The resampling kernel, or kernels for ranges of zooms
+	Resampling mapimage.Resampling `json:"resampling"`
+	// go, vips or chunked, when not set it is picked by autoBackend (vips,
+	// without libvips in the build, falls back to chunked)
+	Backend string `json:"backend"`
+}
+
+// autoBackend is go, or for an image over a GB vips or (only streaming PNGs)
+// chunked
+func autoBackend(format string, approxSize int) string {
+	if approxSize <= 1000 {
+		return "go"
+	}
+	if _, ok := mapimage.LookupBackend("vips"); ok {
+		return "vips"
+	}
+	if format == "png" {
+		return "chunked"
+	}
+	return "go"
+}
+
+// backendFallbacks is the backend used instead of one left out of the build,
+// i.e. the chunked Go one when there is no libvips
+var backendFallbacks = map[string]string{
+	"vips": "chunked",
 }
 
 func DisallowUnknownFields(d *json.Decoder) *json.Decoder {
@@ -53,8 +101,24 @@ func init() {
 		log.Fatal(err)
 	}
 
+	log.Printf("Backends: %v\n", strings.Join(mapimage.Backends(), ", "))
 	maps.images = make([]mapimage.MapImage, 0)
+	maps.filenames = make(map[string]string)
 	for _, loadedImage := range loadedImages {
+		georef, err := georeferenceImage(loadedImage)
+		if err != nil {
+			log.Printf("Skipping %v, could not georeference: %v\n", loadedImage.Id, err)
+			continue
+		}
+		options, err := tileOptions(loadedImage)
+		if err != nil {
+			log.Printf("Skipping %v, bad tile options: %v\n", loadedImage.Id, err)
+			continue
+		}
+		for _, outlier := range georef.Outliers {
+			log.Printf("%v: ignoring outlier reference point, geo: %v pixel: %v\n", loadedImage.Id, outlier.Geographic, outlier.Pixel)
+		}
+
 		f, err := os.Open(fmt.Sprintf("./images/%s", loadedImage.Filename))
 		if err == nil {
 			if fileConfig, format, err := image.DecodeConfig(f); err == nil {
@@ -62,27 +126,42 @@ func init() {
 				approxSize := (3 * fileConfig.Width * fileConfig.Height) / 1024 / 1024
 
 				log.Printf("%v is approx %v MB, in format %v\n", loadedImage.Filename, approxSize, format)
-				var mi mapimage.MapImage
-				if approxSize > 1000 {
-					log.Printf("Using VIPS for %v\n", loadedImage.Filename)
-					mi = mapimage.NewVIPSImageInfo(
-						loadedImage.Id,
-						loadedImage.Name,
-						loadedImage.ReferencePoints,
-						f)
-
-				} else {
-					log.Printf("Using Go Image for %v\n", loadedImage.Filename)
-					mi = mapimage.NewImageInfo(
-						loadedImage.Id,
-						loadedImage.Name,
-						loadedImage.ReferencePoints,
-						f)
-					log.Printf(" >> MinZoom: %v MaxZoom: %v\n", mi.MinZoom(), mi.MaxZoom())
+				log.Printf(" >> %v transformation from %v reference points, RMSE: %.2f pixels\n",
+					georef.Transformation, len(georef.ReferencePoints), georef.PixelResiduals().RMSE)
+				backend := loadedImage.Backend
+				if backend == "" {
+					backend = autoBackend(format, approxSize)
+				}
+				newImage, ok := mapimage.LookupBackend(backend)
+				if fallback, known := backendFallbacks[backend]; !ok && known {
+					log.Printf("%v: no %v backend in this build, using %v\n", loadedImage.Id, backend, fallback)
+					backend = fallback
+					newImage, ok = mapimage.LookupBackend(backend)
+				}
+				if !ok {
+					log.Printf("Skipping %v, unknown backend %q\n", loadedImage.Id, backend)
+					f.Close()
+					continue
 				}
 
+				log.Printf("Using the %v backend for %v\n", backend, loadedImage.Filename)
+				mi, err := newImage(
+					loadedImage.Id,
+					loadedImage.Name,
+					georef,
+					options,
+					f,
+					fmt.Sprintf("./media/%s", loadedImage.Id))
+				if err != nil {
+					log.Printf("Skipping %v: %v\n", loadedImage.Id, err)
+					f.Close()
+					continue
+				}
+				log.Printf(" >> MinZoom: %v MaxZoom: %v\n", mi.MinZoom(), mi.MaxZoom())
+
 				mi = mapimage.FilesystemCachedImage(mi)
 				maps.images = append(maps.images, mi)
+				maps.filenames[loadedImage.Id] = fmt.Sprintf("./images/%s", loadedImage.Filename)
 			}
 		} else {
 			f.Close()
@@ -92,6 +171,116 @@ func init() {
 	log.Println("Running")
 }
 
+// georeferenceImage georeferences an image from its referencePoints or, when
+// it has none, from the tags of a GeoTIFF or the world file next to it
+func georeferenceImage(config ImageConfig) (mapimage.Georeference, error) {
+	filename := fmt.Sprintf("./images/%s", config.Filename)
+
+	if config.Calibration != "" && len(config.ReferencePoints) == 0 {
+		calibration, err := mapimage.ReadCalibration(fmt.Sprintf("./images/%s", config.Calibration))
+		if err != nil {
+			return mapimage.Georeference{}, err
+		}
+		log.Printf("%v: georeferencing from %v\n", config.Id, config.Calibration)
+		config.ReferencePoints = calibration.ReferencePoints
+		if calibration.CRS != "" {
+			config.CRS = calibration.CRS
+		}
+		if config.Transformation == "" {
+			config.Transformation = calibration.Transformation
+		}
+	}
+
+	var geoTIFF *mapimage.GeoTIFF
+	if ext := strings.ToLower(filepath.Ext(filename)); len(config.ReferencePoints) == 0 && (ext == ".tif" || ext == ".tiff") {
+		if g, err := readGeoTIFF(filename); err == nil {
+			geoTIFF = &g
+		} else {
+			log.Printf("%v: no GeoTIFF georeference: %v\n", config.Id, err)
+		}
+	}
+
+	crs, err := mapimage.ParseCRS(config.CRS)
+	if geoTIFF != nil && config.CRS == "" {
+		crs, err = geoTIFF.CRS()
+	}
+	if err != nil {
+		return mapimage.Georeference{}, err
+	}
+	if config.Datum != nil {
+		datum, err := mapimage.NewDatum(*config.Datum)
+		if err == nil {
+			crs, err = crs.WithDatum(datum)
+		}
+		if err != nil {
+			return mapimage.Georeference{}, err
+		}
+	}
+
+	if geoTIFF != nil {
+		log.Printf("%v: georeferencing from its GeoTIFF tags\n", config.Id)
+		return geoTIFF.Georeference(config.Transformation, crs, config.OutlierThreshold)
+	}
+	if worldFile, ok := mapimage.FindWorldFile(filename); ok && len(config.ReferencePoints) == 0 {
+		log.Printf("%v: georeferencing from %v\n", config.Id, worldFile)
+		return georeferenceFromWorldFile(worldFile, crs)
+	}
+	return mapimage.NewGeoreference(config.Transformation, crs, config.ReferencePoints, config.OutlierThreshold)
+}
+
+// tileOptions are how the image's map tiles are drawn
+func tileOptions(config ImageConfig) (mapimage.TileOptions, error) {
+	noData, err := mapimage.ParseColor(config.NoData)
+	if err != nil {
+		return mapimage.TileOptions{}, err
+	}
+	format, err := mapimage.ParseTileFormat(config.TileFormat)
+	if err != nil {
+		return mapimage.TileOptions{}, err
+	}
+	if !format.Supported() {
+		log.Printf("%v: %v tiles aren't supported (libvips can't save them), using png\n", config.Id, format)
+		format = mapimage.PNGTile
+	}
+	if config.TileQuality < 0 || config.TileQuality > 100 {
+		return mapimage.TileOptions{}, fmt.Errorf("tileQuality %v isn't 1-100", config.TileQuality)
+	}
+	return mapimage.TileOptions{
+		NoData:     noData,
+		Format:     format,
+		Quality:    config.TileQuality,
+		Resampling: config.Resampling,
+		// (Built the first time the image is loaded)
+		OverviewDir: fmt.Sprintf("./media/%s/overviews", config.Id),
+	}, nil
+}
+
+func readGeoTIFF(filename string) (mapimage.GeoTIFF, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return mapimage.GeoTIFF{}, err
+	}
+	defer f.Close()
+	return mapimage.ReadGeoTIFF(f)
+}
+
+// georeferenceFromWorldFile reads the world file that sits next to an image
+// (its coordinates are in crs)
+func georeferenceFromWorldFile(filename string, crs mapimage.CRS) (mapimage.Georeference, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return mapimage.Georeference{}, err
+	}
+	defer f.Close()
+
+	wf, err := mapimage.ReadWorldFile(f)
+	if err != nil {
+		return mapimage.Georeference{}, err
+	}
+	toCRS := wf.Transformation()
+	return mapimage.NewGeoreferenceFromTransformation(mapimage.AffineModel, crs, &toCRS), nil
+}
+
 func (obj db) ListAll() []mapimage.MapImage {
 	items := make([]mapimage.MapImage, 0)
 	for idx, _ := range obj.images {
@@ -109,11 +298,94 @@ func (obj db) GetById(id string) (mapimage.MapImage, error) {
 	return nil, errors.New("not found")
 }
 
+// subcommands each write an image out to a file, instead of running the
+// server, e.g. "serverd cog <id> <output.tif>"
+var subcommands = map[string]func(args []string){
+	"cog":    exportCOG,
+	"points": exportPoints,
+	"vrt":    exportVRT,
+}
+
+// exportImage is the common part of the subcommands: it parses the flags, then
+// has write write the image (the first argument) to the file (the second)
+func exportImage(flags *flag.FlagSet, args []string, usage string, write func(io.Writer, mapimage.MapImage) error) {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s %s\n", os.Args[0], flags.Name(), usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	mi, err := maps.GetById(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("%v: %v", flags.Arg(0), err)
+	}
+	out, err := os.Create(flags.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+
+	if err := write(out, mi); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Wrote %v to %v\n", mi.Id(), flags.Arg(1))
+}
+
+// exportCOG writes an image out as a Cloud Optimized GeoTIFF
+func exportCOG(args []string) {
+	flags := flag.NewFlagSet("cog", flag.ExitOnError)
+	webMercator := flags.Bool("webmercator", false, "warp the image to Web Mercator (EPSG:3857)")
+	exportImage(flags, args, "[-webmercator] <id> <output.tif>", func(out io.Writer, mi mapimage.MapImage) error {
+		return mapimage.WriteCOG(out, mi, mapimage.COGOptions{WebMercator: *webMercator})
+	})
+}
+
+// exportPoints writes the reference points of an image for the QGIS
+// Georeferencer
+func exportPoints(args []string) {
+	flags := flag.NewFlagSet("points", flag.ExitOnError)
+	exportImage(flags, args, "<id> <output.points>", mapimage.WriteQGISPoints)
+}
+
+// exportVRT writes a GDAL VRT of an image, with its reference points as GCPs
+func exportVRT(args []string) {
+	flags := flag.NewFlagSet("vrt", flag.ExitOnError)
+	exportImage(flags, args, "<id> <output.vrt>", func(out io.Writer, mi mapimage.MapImage) error {
+		source, err := filepath.Abs(maps.filenames[mi.Id()])
+		if err != nil {
+			return err
+		}
+		return mapimage.WriteVRT(out, mi, source)
+	})
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			subcommand(os.Args[2:])
+			return
+		}
+	}
+
 	router := mux.NewRouter()
 	api := router.PathPrefix("/api").Subrouter()
 
 	mapimage.AttachApi(maps, api, "/imageinfo", "/file")
+	api.Handle("/backends", http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			b, err := json.Marshal(mapimage.Backends())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(b)
+		}))
 
 	// NB: the path is just hardcoded here!
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./ui/build")))